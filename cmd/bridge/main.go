@@ -16,7 +16,11 @@ import (
 	"github.com/wy51ai/moltbotCNAPP/internal/bridge"
 	"github.com/wy51ai/moltbotCNAPP/internal/clawdbot"
 	"github.com/wy51ai/moltbotCNAPP/internal/config"
+	"github.com/wy51ai/moltbotCNAPP/internal/dingtalk"
 	"github.com/wy51ai/moltbotCNAPP/internal/feishu"
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+	"github.com/wy51ai/moltbotCNAPP/internal/ratelimit"
+	"github.com/wy51ai/moltbotCNAPP/internal/wecom"
 )
 
 func main() {
@@ -38,12 +42,8 @@ func main() {
 		dir, _ := config.Dir()
 		pidPath := filepath.Join(dir, "bridge.pid")
 		if pid, err := readPID(pidPath); err == nil {
-			stopProcess(pid)
-			for i := 0; i < 10; i++ {
-				time.Sleep(200 * time.Millisecond)
-				if !isProcessRunning(pid) {
-					break
-				}
+			if stopProcess(pid) == nil {
+				waitForExit(pid)
 			}
 			os.Remove(pidPath)
 		}
@@ -54,7 +54,7 @@ func main() {
 		}
 		cmdRun()
 	default:
-		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\nUsage:\n  clawdbot-bridge start [fs_app_id=xxx fs_app_secret=yyy]\n  clawdbot-bridge stop\n  clawdbot-bridge status\n  clawdbot-bridge restart\n  clawdbot-bridge run\n")
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\nUsage:\n  clawdbot-bridge start [fs_app_id=xxx fs_app_secret=yyy] [dt_client_id=xxx dt_client_secret=yyy]\n  clawdbot-bridge stop\n  clawdbot-bridge status\n  clawdbot-bridge restart\n  clawdbot-bridge run\n", cmd)
 		os.Exit(1)
 	}
 }
@@ -138,15 +138,33 @@ func cmdStop() {
 		os.Exit(1)
 	}
 
-	for i := 0; i < 10; i++ {
+	waitForExit(pid)
+
+	os.Remove(pidPath)
+	fmt.Println("Stopped")
+}
+
+// waitForExit waits for a stopped bridge to finish draining its requests
+func waitForExit(pid int) {
+	drainMs := config.DefaultDrainTimeoutMs
+	if cfg, err := config.Load(); err == nil {
+		drainMs = cfg.Bridge.DrainTimeoutMs
+	}
+	// Leave time for the final edits after the drain times out
+	deadline := time.Now().Add(time.Duration(drainMs)*time.Millisecond + 15*time.Second)
+
+	waiting := false
+	for time.Now().Before(deadline) {
 		time.Sleep(200 * time.Millisecond)
 		if !isProcessRunning(pid) {
-			break
+			return
+		}
+		if !waiting {
+			fmt.Println("Waiting for running requests to finish...")
+			waiting = true
 		}
 	}
-
-	os.Remove(pidPath)
-	fmt.Println("Stopped")
+	fmt.Printf("Process %d is still running\n", pid)
 }
 
 func cmdStatus() {
@@ -174,24 +192,70 @@ func cmdRun() {
 		log.Fatalf("[Main] Failed to load config: %v", err)
 	}
 
-	log.Printf("[Main] Loaded config: AppID=%s, Gateway=127.0.0.1:%d, AgentID=%s",
-		cfg.Feishu.AppID, cfg.Clawdbot.GatewayPort, cfg.Clawdbot.AgentID)
+	messengers, err := newMessengers(cfg)
+	if err != nil {
+		log.Fatalf("[Main] Failed to create messengers: %v", err)
+	}
+	platforms := make([]string, 0, len(messengers))
+	for _, m := range messengers {
+		platforms = append(platforms, m.Platform())
+	}
+
+	log.Printf("[Main] Loaded config: Platforms=%s, Gateway=127.0.0.1:%d, AgentID=%s",
+		strings.Join(platforms, ","), cfg.Clawdbot.GatewayPort, cfg.Clawdbot.AgentID)
 
 	clawdbotClient := clawdbot.NewClient(
 		cfg.Clawdbot.GatewayPort,
 		cfg.Clawdbot.GatewayToken,
 		cfg.Clawdbot.AgentID,
 	)
+	clawdbotClient.Start()
+	defer clawdbotClient.Close()
 
-	bridgeInstance := bridge.NewBridge(nil, clawdbotClient, cfg.Feishu.ThinkingThresholdMs)
+	dir, err := config.Dir()
+	if err != nil {
+		log.Fatalf("[Main] Failed to resolve config dir: %v", err)
+	}
 
-	feishuClient := feishu.NewClient(
-		cfg.Feishu.AppID,
-		cfg.Feishu.AppSecret,
-		bridgeInstance.HandleMessage,
-	)
+	agentRunTimeouts := make(map[string]time.Duration, len(cfg.Clawdbot.AgentRunTimeoutMs))
+	for agentID, ms := range cfg.Clawdbot.AgentRunTimeoutMs {
+		agentRunTimeouts[agentID] = time.Duration(ms) * time.Millisecond
+	}
+
+	bridgeInstance, err := bridge.NewBridge(clawdbotClient, bridge.Options{
+		ThinkingMs:        cfg.Bridge.ThinkingThresholdMs,
+		StreamIntervalMs:  cfg.Bridge.StreamIntervalMs,
+		MaxConcurrentRuns: cfg.Clawdbot.MaxConcurrentRuns,
+		StateDir:          dir,
+		MaxImageBytes:     cfg.Bridge.MaxImageBytes,
+		ImageTypes:        cfg.Bridge.ImageTypes,
+		MaxFileBytes:      cfg.Bridge.MaxFileBytes,
+		CodeFileBytes:     cfg.Bridge.CodeFileBytes,
+		ReplyMode:         cfg.Bridge.ReplyMode,
+		SessionStrategy:   cfg.Bridge.SessionStrategy,
+		GroupHeader:       cfg.Bridge.GroupHeader,
+		P2PHeader:         cfg.Bridge.P2PHeader,
+		TriggerMode:       cfg.Bridge.TriggerMode,
+		Triggers: bridge.TriggerRules{
+			BotNames:    cfg.Bridge.BotNames,
+			Keywords:    cfg.Bridge.Keywords,
+			Patterns:    cfg.Bridge.Patterns,
+			ActionVerbs: cfg.Bridge.ActionVerbs,
+		},
+		Admins:           cfg.Bridge.Admins,
+		SenderLimit:      ratelimit.Limit(cfg.Bridge.SenderLimit),
+		ChatLimit:        ratelimit.Limit(cfg.Bridge.ChatLimit),
+		RunTimeout:       time.Duration(cfg.Clawdbot.RunTimeoutMs) * time.Millisecond,
+		AgentRunTimeouts: agentRunTimeouts,
+		Progress:         cfg.Bridge.Progress,
+	})
+	if err != nil {
+		log.Fatalf("[Main] Failed to create bridge: %v", err)
+	}
 
-	bridgeInstance.SetFeishuClient(feishuClient)
+	for _, m := range messengers {
+		bridgeInstance.AddMessenger(m)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -201,7 +265,7 @@ func cmdRun() {
 
 	errChan := make(chan error, 1)
 	go func() {
-		if err := feishuClient.Start(ctx); err != nil {
+		if err := bridgeInstance.Run(ctx); err != nil {
 			errChan <- err
 		}
 	}()
@@ -212,15 +276,70 @@ func cmdRun() {
 	select {
 	case <-sigChan:
 		log.Println("[Main] Received shutdown signal, stopping...")
-		cancel()
 	case err := <-errChan:
 		log.Printf("[Main] Error: %v", err)
-		cancel()
+	}
+
+	// Stop receiving messages, then let running requests finish
+	cancel()
+	go func() {
+		<-sigChan
+		log.Println("[Main] Received second signal, exiting now")
+		os.Exit(1)
+	}()
+
+	drainTimeout := time.Duration(cfg.Bridge.DrainTimeoutMs) * time.Millisecond
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancelDrain()
+	if err := bridgeInstance.Shutdown(drainCtx); err != nil {
+		log.Printf("[Main] Requests still running after %v were cancelled", drainTimeout)
 	}
 
 	log.Println("[Main] ClawdBot Bridge stopped")
 }
 
+// newMessengers creates an adapter for every IM platform enabled in config
+func newMessengers(cfg *config.Config) ([]im.Messenger, error) {
+	var messengers []im.Messenger
+
+	if cfg.Feishu != nil {
+		messengers = append(messengers, feishu.NewClient(cfg.Feishu.AppID, cfg.Feishu.AppSecret, feishu.Options{
+			Mode:              cfg.Feishu.Mode,
+			Listen:            cfg.Feishu.Listen,
+			Path:              cfg.Feishu.Path,
+			VerificationToken: cfg.Feishu.VerificationToken,
+			EncryptKey:        cfg.Feishu.EncryptKey,
+			ReplyFormat:       cfg.Feishu.ReplyFormat,
+		}))
+	}
+	if cfg.DingTalk != nil {
+		messengers = append(messengers, dingtalk.NewClient(
+			cfg.DingTalk.ClientID,
+			cfg.DingTalk.ClientSecret,
+			cfg.DingTalk.RobotCode,
+			cfg.DingTalk.APIBase,
+		))
+	}
+	if cfg.WeCom != nil {
+		client, err := wecom.NewClient(wecom.Config{
+			CorpID:         cfg.WeCom.CorpID,
+			CorpSecret:     cfg.WeCom.CorpSecret,
+			AgentID:        cfg.WeCom.AgentID,
+			Token:          cfg.WeCom.Token,
+			EncodingAESKey: cfg.WeCom.EncodingAESKey,
+			Listen:         cfg.WeCom.Listen,
+			Path:           cfg.WeCom.Path,
+			APIBase:        cfg.WeCom.APIBase,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("wecom: %w", err)
+		}
+		messengers = append(messengers, client)
+	}
+
+	return messengers, nil
+}
+
 func isRunning(pidPath string) bool {
 	pid, err := readPID(pidPath)
 	if err != nil {
@@ -237,13 +356,37 @@ func readPID(pidPath string) (int, error) {
 	return strconv.Atoi(strings.TrimSpace(string(data)))
 }
 
-// applyConfigArgs parses key=value args and saves to bridge.json
+// configArg maps a command line key to a setting in bridge.json
+type configArg struct {
+	section string // top-level block, empty for top-level keys
+	key     string
+	number  bool
+}
+
+// configArgs lists the key=value arguments accepted by start, restart and run
+var configArgs = map[string]configArg{
+	"fs_app_id":        {section: "feishu", key: "app_id"},
+	"fs_app_secret":    {section: "feishu", key: "app_secret"},
+	"dt_client_id":     {section: "dingtalk", key: "client_id"},
+	"dt_client_secret": {section: "dingtalk", key: "client_secret"},
+	"agent_id":         {key: "agent_id"},
+	"thinking_ms":      {key: "thinking_threshold_ms", number: true},
+	"stream_ms":        {key: "stream_interval_ms", number: true},
+	"max_runs":         {key: "max_concurrent_runs", number: true},
+}
+
+// applyConfigArgs parses key=value args and saves to bridge.json.
+// Settings that are not passed on the command line are left untouched.
 func applyConfigArgs(args []string) {
 	kv := parseKeyValue(args)
-	appID := kv["fs_app_id"]
-	appSecret := kv["fs_app_secret"]
 
-	if appID == "" && appSecret == "" {
+	updates := make(map[string]string)
+	for name, value := range kv {
+		if _, ok := configArgs[name]; ok {
+			updates[name] = value
+		}
+	}
+	if len(updates) == 0 {
 		return
 	}
 
@@ -251,45 +394,50 @@ func applyConfigArgs(args []string) {
 	if err != nil {
 		log.Fatal(err)
 	}
+	path := filepath.Join(dir, "bridge.json")
 
-	// Read existing config if present (try bridge.json first)
-	var cfg bridgeConfigJSON
-	if data, err := os.ReadFile(filepath.Join(dir, "bridge.json")); err == nil {
-		json.Unmarshal(data, &cfg)
+	// Read existing config if present
+	cfg := make(map[string]interface{})
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			log.Fatalf("Failed to parse %s: %v", path, err)
+		}
 	}
 
-	if appID != "" {
-		cfg.Feishu.AppID = appID
-	}
-	if appSecret != "" {
-		cfg.Feishu.AppSecret = appSecret
-	}
-	if v, ok := kv["agent_id"]; ok {
-		cfg.AgentID = v
-	}
-	if v, ok := kv["thinking_ms"]; ok {
-		if ms, err := strconv.Atoi(v); err == nil {
-			cfg.ThinkingThresholdMs = ms
+	for name, value := range updates {
+		arg := configArgs[name]
+
+		var v interface{} = value
+		if arg.number {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				log.Fatalf("Invalid value for %s: %q is not a number", name, value)
+			}
+			v = n
 		}
+
+		if arg.section == "" {
+			cfg[arg.key] = v
+			continue
+		}
+		section, _ := cfg[arg.section].(map[string]interface{})
+		if section == nil {
+			section = make(map[string]interface{})
+		}
+		section[arg.key] = v
+		cfg[arg.section] = section
 	}
 
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Fatalf("Failed to create %s: %v", dir, err)
+	}
 	data, _ := json.MarshalIndent(cfg, "", "  ")
-	path := filepath.Join(dir, "bridge.json")
 	if err := os.WriteFile(path, data, 0600); err != nil {
 		log.Fatalf("Failed to save config: %v", err)
 	}
 	fmt.Printf("Saved config to %s\n", path)
 }
 
-type bridgeConfigJSON struct {
-	Feishu struct {
-		AppID     string `json:"app_id"`
-		AppSecret string `json:"app_secret"`
-	} `json:"feishu"`
-	ThinkingThresholdMs int    `json:"thinking_threshold_ms,omitempty"`
-	AgentID             string `json:"agent_id,omitempty"`
-}
-
 func parseKeyValue(args []string) map[string]string {
 	result := make(map[string]string)
 	for _, arg := range args {
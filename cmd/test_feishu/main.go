@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"time"
 
@@ -12,10 +13,11 @@ func main() {
 	appSecret := "SECRET_PLACEHOLDER" // TODO: Use env var or config
 	chatID := "oc_PLACEHOLDER"
 
-	client := feishu.NewClient(appID, appSecret, nil)
+	client := feishu.NewClient(appID, appSecret, feishu.Options{})
+	ctx := context.Background()
 
 	log.Println("Sending test message...")
-	msgID, err := client.SendMessage(chatID, "Debug Test: Starting...")
+	msgID, err := client.SendMessage(ctx, chatID, "Debug Test: Starting...")
 	if err != nil {
 		log.Fatalf("SendMessage failed: %v", err)
 	}
@@ -25,7 +27,7 @@ func main() {
 		time.Sleep(1 * time.Second)
 		log.Printf("Updating message %d...", i)
 		updateText := "Debug Test: Update " + string(rune('0'+i)) + " ▌"
-		err = client.UpdateMessage(msgID, updateText)
+		err = client.UpdateMessage(ctx, msgID, updateText)
 		if err != nil {
 			log.Printf("UpdateMessage failed: %v", err)
 		} else {
@@ -34,5 +36,5 @@ func main() {
 	}
 
 	time.Sleep(1 * time.Second)
-	client.UpdateMessage(msgID, "Debug Test: Finished")
+	client.UpdateMessage(ctx, msgID, "Debug Test: Finished")
 }
@@ -0,0 +1,179 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+const (
+	// accessReloadInterval is how often the access file is checked for changes
+	accessReloadInterval = 5 * time.Second
+	// unknownChatReplyInterval limits the unknown chat reply to one per chat
+	unknownChatReplyInterval = time.Hour
+)
+
+// accessRule lists chats, users and organizations. Chats match by chat ID
+// or chat key ("feishu:oc_xxx"); users by any of their IDs.
+type accessRule struct {
+	Chats   []string `json:"chats,omitempty"`
+	Users   []string `json:"users,omitempty"`
+	Tenants []string `json:"tenants,omitempty"`
+}
+
+// accessConfig is the content of access.json. Denied messages are always
+// dropped; when the allow rule is not empty, only messages it matches pass.
+type accessConfig struct {
+	Allow accessRule `json:"allow"`
+	Deny  accessRule `json:"deny"`
+	// UnknownChatReply answers chats not on the allow list, empty stays silent
+	UnknownChatReply string `json:"unknown_chat_reply,omitempty"`
+}
+
+// Access decisions
+const (
+	accessAllowed = iota
+	accessDenied
+	accessUnknown
+)
+
+// accessControl applies the rules in access.json, reloading the file when
+// it changes so access can be granted or revoked without a restart
+type accessControl struct {
+	path string
+
+	mu        sync.Mutex
+	config    accessConfig
+	modTime   time.Time
+	lastCheck time.Time
+	replied   map[string]time.Time
+	// badModTime is the version of the file that failed to load, so it is
+	// reported once rather than on every check
+	badModTime time.Time
+}
+
+// newAccessControl loads the access file. A file that exists but cannot be
+// read or parsed is an error, so the bridge does not start unrestricted.
+func newAccessControl(path string) (*accessControl, error) {
+	a := &accessControl{path: path, replied: make(map[string]time.Time)}
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// reload re-reads the access file if it changed. A file that fails to load
+// keeps the previous rules and is tried again once it changes. It must be
+// called with a.mu held, except from newAccessControl.
+func (a *accessControl) reload() error {
+	a.lastCheck = time.Now()
+	if a.path == "" {
+		return nil
+	}
+
+	info, err := os.Stat(a.path)
+	if os.IsNotExist(err) {
+		if !a.modTime.IsZero() {
+			log.Printf("[Bridge] %s removed, access is no longer restricted", a.path)
+		}
+		a.config, a.modTime, a.badModTime = accessConfig{}, time.Time{}, time.Time{}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check %s: %w", a.path, err)
+	}
+	if info.ModTime().Equal(a.modTime) || info.ModTime().Equal(a.badModTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(a.path)
+	if err != nil {
+		a.badModTime = info.ModTime()
+		return fmt.Errorf("failed to read %s: %w", a.path, err)
+	}
+	var config accessConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		a.badModTime = info.ModTime()
+		return fmt.Errorf("failed to parse %s: %w", a.path, err)
+	}
+	a.config, a.modTime, a.badModTime = config, info.ModTime(), time.Time{}
+	log.Printf("[Bridge] Loaded access rules from %s", a.path)
+	return nil
+}
+
+// check decides whether msg may reach the agent. For chats not on the
+// allow list it also returns the reply to send, at most once per interval.
+func (a *accessControl) check(msg *im.Message) (decision int, reply string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if time.Since(a.lastCheck) > accessReloadInterval {
+		if err := a.reload(); err != nil {
+			log.Printf("[Bridge] %v, keeping previous access rules", err)
+		}
+	}
+
+	switch {
+	case a.config.Deny.matches(msg):
+		return accessDenied, ""
+	case a.config.Allow.empty() || a.config.Allow.matches(msg):
+		return accessAllowed, ""
+	}
+
+	if a.config.UnknownChatReply == "" {
+		return accessUnknown, ""
+	}
+	key := chatKey(msg)
+	if time.Since(a.replied[key]) < unknownChatReplyInterval {
+		return accessUnknown, ""
+	}
+	a.replied[key] = time.Now()
+	return accessUnknown, a.config.UnknownChatReply
+}
+
+func (r accessRule) empty() bool {
+	return len(r.Chats) == 0 && len(r.Users) == 0 && len(r.Tenants) == 0
+}
+
+func (r accessRule) matches(msg *im.Message) bool {
+	sender := msg.Sender
+	return contains(r.Chats, msg.ChatID, chatKey(msg)) ||
+		contains(r.Users, sender.ID, sender.UnionID, sender.UserID) ||
+		contains(r.Tenants, sender.TenantKey)
+}
+
+// contains reports whether list has any of the non-empty values
+func contains(list []string, values ...string) bool {
+	for _, item := range list {
+		for _, value := range values {
+			if value != "" && item == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// allowed applies the access rules to msg and answers chats that are not
+// on the allow list when an unknown chat reply is configured
+func (b *Bridge) allowed(m im.Messenger, msg *im.Message) bool {
+	decision, reply := b.access.check(msg)
+	switch decision {
+	case accessAllowed:
+		return true
+	case accessDenied:
+		log.Printf("[Bridge] Dropping message from denied chat %s (sender %s)", chatKey(msg), msg.Sender.ID)
+	default:
+		log.Printf("[Bridge] Dropping message from unknown chat %s (sender %s)", chatKey(msg), msg.Sender.ID)
+		// In groups, only answer messages addressed to the bot
+		if reply != "" && (msg.ChatType == im.ChatTypeP2P || mentionsBot(msg.Mentions)) {
+			go b.reply(m, msg, reply)
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package bridge
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+func TestAccessControl(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.json")
+	a, err := newAccessControl(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := &im.Message{Platform: "feishu", ChatID: "oc_1", ChatType: im.ChatTypeP2P,
+		Sender: im.Sender{ID: "ou_alice", TenantKey: "tk-1"}}
+	mallory := &im.Message{Platform: "feishu", ChatID: "oc_2", ChatType: im.ChatTypeP2P,
+		Sender: im.Sender{ID: "ou_mallory", TenantKey: "tk-1"}}
+	outsider := &im.Message{Platform: "feishu", ChatID: "oc_3", ChatType: im.ChatTypeP2P,
+		Sender: im.Sender{ID: "ou_x", TenantKey: "tk-2"}}
+
+	// Without an access file everything is allowed
+	if decision, _ := a.check(outsider); decision != accessAllowed {
+		t.Fatalf("decision without rules = %d, want allowed", decision)
+	}
+
+	writeAccess(t, a, `{"allow":{"tenants":["tk-1"]},"deny":{"users":["ou_mallory"]},"unknown_chat_reply":"未授权"}`)
+
+	if decision, _ := a.check(alice); decision != accessAllowed {
+		t.Fatalf("alice decision = %d, want allowed", decision)
+	}
+	if decision, reply := a.check(mallory); decision != accessDenied || reply != "" {
+		t.Fatalf("mallory decision = %d %q, want denied without reply", decision, reply)
+	}
+	if decision, reply := a.check(outsider); decision != accessUnknown || reply != "未授权" {
+		t.Fatalf("outsider decision = %d %q, want unknown with reply", decision, reply)
+	}
+	if _, reply := a.check(outsider); reply != "" {
+		t.Fatalf("second outsider reply = %q, want none", reply)
+	}
+
+	// Chats can be allowed by chat key without a restart
+	writeAccess(t, a, `{"allow":{"chats":["feishu:oc_3"]}}`)
+	if decision, _ := a.check(outsider); decision != accessAllowed {
+		t.Fatalf("outsider decision after reload = %d, want allowed", decision)
+	}
+	if decision, _ := a.check(alice); decision != accessUnknown {
+		t.Fatalf("alice decision after reload = %d, want unknown", decision)
+	}
+
+	// A broken file keeps the previous rules
+	writeAccess(t, a, `{"allow":`)
+	if decision, _ := a.check(alice); decision != accessUnknown {
+		t.Fatalf("alice decision after broken file = %d, want unknown", decision)
+	}
+
+	// Fixing the file is picked up even though the broken version was skipped
+	writeAccess(t, a, `{"allow":{"users":["ou_alice"]}}`)
+	if decision, _ := a.check(alice); decision != accessAllowed {
+		t.Fatalf("alice decision after fixed file = %d, want allowed", decision)
+	}
+}
+
+func TestAccessControlRefusesBrokenFileAtStartup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.json")
+	if err := os.WriteFile(path, []byte(`{"allow":`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newAccessControl(path); err == nil {
+		t.Fatal("newAccessControl() with a broken file succeeded, want an error")
+	}
+}
+
+// writeAccess replaces the access file and makes the next check reload it
+func writeAccess(t *testing.T, a *accessControl, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(a.path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Files written in quick succession may share a modification time
+	last := a.modTime
+	if a.badModTime.After(last) {
+		last = a.badModTime
+	}
+	mtime := last.Add(time.Second)
+	if mtime.Before(time.Now()) {
+		mtime = time.Now()
+	}
+	if err := os.Chtimes(a.path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	a.mu.Lock()
+	a.lastCheck = time.Time{}
+	a.mu.Unlock()
+}
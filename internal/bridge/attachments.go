@@ -0,0 +1,190 @@
+package bridge
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/clawdbot"
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+// Attachment defaults used when Options leaves them unset
+const defaultMaxImageBytes = 10 << 20
+
+var defaultImageTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+// attachmentPolicy limits what is downloaded and forwarded to the agent
+type attachmentPolicy struct {
+	maxImageBytes int64
+	imageTypes    []string
+}
+
+func newAttachmentPolicy(opts Options) attachmentPolicy {
+	p := attachmentPolicy{
+		maxImageBytes: opts.MaxImageBytes,
+		imageTypes:    opts.ImageTypes,
+	}
+	if p.maxImageBytes <= 0 {
+		p.maxImageBytes = defaultMaxImageBytes
+	}
+	if len(p.imageTypes) == 0 {
+		p.imageTypes = defaultImageTypes
+	}
+	return p
+}
+
+// checkImage validates a downloaded image and returns its MIME type
+func (p attachmentPolicy) checkImage(data []byte) (string, error) {
+	if int64(len(data)) > p.maxImageBytes {
+		return "", fmt.Errorf("图片大小 %s 超过 %s 的限制", formatBytes(int64(len(data))), formatBytes(p.maxImageBytes))
+	}
+
+	// Platforms do not reliably report a content type, so sniff it
+	mimeType := http.DetectContentType(data)
+	if i := strings.Index(mimeType, ";"); i >= 0 {
+		mimeType = mimeType[:i]
+	}
+	for _, allowed := range p.imageTypes {
+		if strings.EqualFold(allowed, mimeType) {
+			return mimeType, nil
+		}
+	}
+	return "", fmt.Errorf("不支持的图片格式 %s", mimeType)
+}
+
+// heldImage is an image from a group message that did not trigger a reply
+type heldImage struct {
+	messageID string
+	res       im.Resource
+	received  time.Time
+}
+
+// imageHolder keeps the images of group messages that did not trigger a
+// reply until the sender's next message that does. Screenshots cannot
+// mention the bot, so they are usually followed by the question.
+type imageHolder struct {
+	mu      sync.Mutex
+	pending map[string][]heldImage
+}
+
+func newImageHolder() *imageHolder {
+	return &imageHolder{pending: make(map[string][]heldImage)}
+}
+
+// heldImageKey groups held images by chat and sender, empty when the
+// sender is unknown
+func heldImageKey(msg *im.Message) string {
+	if msg.Sender.ID == "" {
+		return ""
+	}
+	return chatKey(msg) + ":" + msg.Sender.ID
+}
+
+// hold keeps the images of msg and returns how many were kept
+func (h *imageHolder) hold(msg *im.Message) int {
+	key := heldImageKey(msg)
+	if key == "" {
+		return 0
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	for k, images := range h.pending {
+		if now.Sub(images[len(images)-1].received) > fileLinkWindow {
+			delete(h.pending, k)
+		}
+	}
+
+	n := 0
+	for _, res := range msg.Resources {
+		if res.Type == im.ResourceImage {
+			h.pending[key] = append(h.pending[key], heldImage{messageID: msg.MessageID, res: res, received: now})
+			n++
+		}
+	}
+	return n
+}
+
+// take removes the images held for the sender of msg that were received
+// within the link window
+func (h *imageHolder) take(msg *im.Message) []heldImage {
+	key := heldImageKey(msg)
+	if key == "" {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var images []heldImage
+	for _, img := range h.pending[key] {
+		if time.Since(img.received) <= fileLinkWindow {
+			images = append(images, img)
+		}
+	}
+	delete(h.pending, key)
+	return images
+}
+
+// loadAttachments downloads the held images and the images of msg for the
+// agent request. It returns nil when there are none or the messenger cannot
+// download.
+func (b *Bridge) loadAttachments(ctx context.Context, m im.Messenger, msg *im.Message, held []heldImage) ([]clawdbot.Attachment, error) {
+	if len(msg.Resources) == 0 && len(held) == 0 {
+		return nil, nil
+	}
+	downloader, ok := m.(im.Downloader)
+	if !ok {
+		return nil, nil
+	}
+
+	images := held
+	for _, res := range msg.Resources {
+		if res.Type == im.ResourceImage {
+			images = append(images, heldImage{messageID: msg.MessageID, res: res})
+		}
+	}
+
+	var attachments []clawdbot.Attachment
+	for _, img := range images {
+		res := img.res
+		data, err := downloader.DownloadResource(ctx, img.messageID, res, b.attachments.maxImageBytes)
+		if errors.Is(err, im.ErrTooLarge) {
+			return nil, fmt.Errorf("图片大小超过 %s 的限制", formatBytes(b.attachments.maxImageBytes))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("下载图片失败: %w", err)
+		}
+		mimeType, err := b.attachments.checkImage(data)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Printf("[Bridge] Attached image %s (%s, %d bytes)", res.Key, mimeType, len(data))
+		attachments = append(attachments, clawdbot.Attachment{
+			Type:     im.ResourceImage,
+			MimeType: mimeType,
+			FileName: res.Name,
+			Content:  base64.StdEncoding.EncodeToString(data),
+		})
+	}
+
+	return attachments, nil
+}
+
+// formatBytes renders a size for messages shown to users
+func formatBytes(n int64) string {
+	if n >= 1<<20 {
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	}
+	return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+}
@@ -0,0 +1,126 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+// pngHeader is enough of a PNG file for content sniffing
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+// fakeDownloader is a messenger that serves resources from memory
+type fakeDownloader struct {
+	resources map[string][]byte
+	downloads atomic.Int32
+}
+
+func (f *fakeDownloader) Platform() string { return "fake" }
+
+func (f *fakeDownloader) Start(ctx context.Context, handler im.Handler) error { return nil }
+
+func (f *fakeDownloader) SendMessage(ctx context.Context, chatID, text string) (string, error) {
+	return "", nil
+}
+
+func (f *fakeDownloader) DownloadResource(ctx context.Context, messageID string, res im.Resource, maxBytes int64) ([]byte, error) {
+	f.downloads.Add(1)
+	data := f.resources[res.Key]
+	if int64(len(data)) > maxBytes {
+		return nil, im.ErrTooLarge
+	}
+	return data, nil
+}
+
+func TestLoadAttachments(t *testing.T) {
+	b, err := NewBridge(nil, Options{MaxImageBytes: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &fakeDownloader{resources: map[string][]byte{
+		"img-png":   pngHeader,
+		"img-large": append(append([]byte{}, pngHeader...), bytes.Repeat([]byte{0}, 64)...),
+		"img-text":  []byte("not an image"),
+	}}
+
+	msg := &im.Message{MessageID: "om_1", Resources: []im.Resource{
+		{Type: im.ResourceImage, Key: "img-png"},
+		{Type: im.ResourceFile, Key: "file-1"},
+	}}
+	attachments, err := b.loadAttachments(context.Background(), m, msg, nil)
+	if err != nil {
+		t.Fatalf("loadAttachments() error: %v", err)
+	}
+	if len(attachments) != 1 || attachments[0].MimeType != "image/png" ||
+		attachments[0].Content != base64.StdEncoding.EncodeToString(pngHeader) {
+		t.Fatalf("loadAttachments() = %+v, want the PNG only", attachments)
+	}
+
+	for key, want := range map[string]string{"img-large": "超过", "img-text": "不支持"} {
+		msg.Resources = []im.Resource{{Type: im.ResourceImage, Key: key}}
+		if _, err := b.loadAttachments(context.Background(), m, msg, nil); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("loadAttachments(%s) error = %v, want %q", key, err, want)
+		}
+	}
+}
+
+func TestLoadAttachmentsWithHeldImages(t *testing.T) {
+	b, err := NewBridge(nil, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gif := []byte("GIF89a")
+	m := &fakeDownloader{resources: map[string][]byte{"img-png": pngHeader, "img-gif": gif}}
+
+	held := []heldImage{{messageID: "om_1", res: im.Resource{Type: im.ResourceImage, Key: "img-png"}}}
+	msg := &im.Message{MessageID: "om_2", Resources: []im.Resource{{Type: im.ResourceImage, Key: "img-gif"}}}
+	attachments, err := b.loadAttachments(context.Background(), m, msg, held)
+	if err != nil {
+		t.Fatalf("loadAttachments() error: %v", err)
+	}
+	if len(attachments) != 2 || attachments[0].MimeType != "image/png" || attachments[1].MimeType != "image/gif" {
+		t.Fatalf("loadAttachments() = %+v, want the held PNG then the GIF", attachments)
+	}
+}
+
+func TestGroupImageWaitsForSenderQuestion(t *testing.T) {
+	b, err := NewBridge(nil, Options{StateDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &fakeDownloader{}
+	b.AddMessenger(m)
+	// Runs give up right away, so no agent is needed
+	b.cancelRuns()
+
+	group := func(id, sender, text string, mentions ...im.Mention) *im.Message {
+		return &im.Message{Platform: "fake", MessageID: id, ChatID: "oc_1", ChatType: im.ChatTypeGroup,
+			Sender: im.Sender{ID: sender}, Content: text, Mentions: mentions}
+	}
+	screenshot := group("om_1", "ou_a", "[图片]")
+	screenshot.Resources = []im.Resource{{Type: im.ResourceImage, Key: "img-1"}}
+
+	if err := b.HandleMessage(screenshot); err != nil {
+		t.Fatal(err)
+	}
+	if running, queued := b.runs.stats(); running+queued != 0 {
+		t.Fatal("screenshot without a trigger started a run")
+	}
+
+	// Questions from other members leave the screenshot alone
+	b.HandleMessage(group("om_2", "ou_b", "这是什么？"))
+	if held := len(b.images.pending[heldImageKey(screenshot)]); held != 1 {
+		t.Fatalf("held %d images after another member's question, want 1", held)
+	}
+
+	b.HandleMessage(group("om_3", "ou_a", "@_user_1 这是什么", im.Mention{Key: "@_user_1", ID: "ou_bot", Bot: true}))
+	if held := len(b.images.pending[heldImageKey(screenshot)]); held != 0 {
+		t.Fatalf("held %d images after the sender's question, want them taken", held)
+	}
+	b.runs.wait()
+}
@@ -1,34 +1,121 @@
 package bridge
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/wy51ai/moltbotCNAPP/internal/clawdbot"
-	"github.com/wy51ai/moltbotCNAPP/internal/feishu"
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+	"github.com/wy51ai/moltbotCNAPP/internal/ratelimit"
 )
 
-var (
-	questionWordPattern = regexp.MustCompile(`\b(?:why|how|what|when|where|who|help)\b`)
-	botTriggerPattern   = regexp.MustCompile(`^(?:alen|clawdbot|bot|助手|智能体)(?:$|[\s,:，：])`)
-	mentionPattern      = regexp.MustCompile(`@_user_\d+\s*`)
+var mentionPattern = regexp.MustCompile(`@_user_\d+\s*`)
 
-	actionVerbs = []string{
-		"帮", "麻烦", "请", "能否", "可以", "解释", "看看",
-		"排查", "分析", "总结", "写", "改", "修", "查", "对比", "翻译",
-	}
+const (
+	// shutdownNotice replaces replies of runs cut short by a shutdown
+	shutdownNotice = "服务重启中，请稍后重试"
+	// finalEditTimeout bounds the messages sent after runs are cancelled
+	finalEditTimeout = 10 * time.Second
 )
 
-// Bridge connects Feishu and ClawdBot
+// Bridge connects IM platforms and ClawdBot
 type Bridge struct {
-	feishuClient   *feishu.Client
-	clawdbotClient *clawdbot.Client
-	thinkingMs     int
-	seenMessages   *messageCache
+	messengers       map[string]im.Messenger
+	clawdbotClient   *clawdbot.Client
+	thinkingMs       int
+	streamIntervalMs int
+	seenMessages     *messageCache
+	seenStore        *seenStore
+	runs             *runQueue
+	settings         *settingsStore
+	attachments      attachmentPolicy
+	files            *fileStager
+	images           *imageHolder
+	codeFileBytes    int
+	replyMode        string
+	sessionStrategy  string
+	headers          headerTemplates
+	names            *nameCache
+	triggers         *triggerRules
+	access           *accessControl
+	limiter          *ratelimit.Limiter
+	senderLimit      ratelimit.Limit
+	chatLimit        ratelimit.Limit
+	limitNotices     limitNotices
+	triggerMode      string
+	progressMode     string
+	admins           map[string]bool
+	active           *activeRuns
+	runTimeout       time.Duration
+	agentRunTimeouts map[string]time.Duration
+
+	// runCtx is cancelled when Shutdown gives up waiting for runs
+	runCtx     context.Context
+	cancelRuns context.CancelFunc
+	closing    atomic.Bool
+
+	commandsMu   sync.RWMutex
+	commands     map[string]Command
+	commandOrder []string
+}
+
+// Options configures a Bridge
+type Options struct {
+	// ThinkingMs delays the "thinking" placeholder, 0 disables it
+	ThinkingMs int
+	// StreamIntervalMs is the minimum time between streaming edits, 0 disables streaming
+	StreamIntervalMs int
+	// MaxConcurrentRuns caps agent runs across all sessions, 0 means unlimited
+	MaxConcurrentRuns int
+	// StateDir holds files the bridge writes at runtime, empty keeps state in memory
+	StateDir string
+	// MaxImageBytes caps the size of images forwarded to the agent, 0 uses 10 MB
+	MaxImageBytes int64
+	// ImageTypes lists the accepted image MIME types, empty allows PNG, JPEG, GIF and WebP
+	ImageTypes []string
+	// MaxFileBytes caps the size of staged file messages, 0 uses 50 MB
+	MaxFileBytes int64
+	// CodeFileBytes sends reply code blocks larger than this as files on
+	// platforms that support it, 0 keeps them inline
+	CodeFileBytes int
+	// ReplyMode is the default reply mode of chats, empty uses ReplyModeQuote
+	ReplyMode string
+	// SessionStrategy is the default session strategy of chats, a strategy
+	// name or a template; empty uses SessionPerChat
+	SessionStrategy string
+	// GroupHeader and P2PHeader are templates for a line put in front of
+	// messages sent to the agent, such as "[{sender} in #{chat}]". The
+	// placeholders are {platform}, {sender}, {sender_id}, {chat} and
+	// {chat_id}; an empty template adds no header.
+	GroupHeader string
+	P2PHeader   string
+	// TriggerMode is the default trigger mode of group chats, empty uses
+	// TriggerHeuristic
+	TriggerMode string
+	// Triggers configures the heuristic trigger mode
+	Triggers TriggerRules
+	// Admins lists the user IDs allowed to change administrative chat
+	// settings such as the trigger mode; empty allows nobody
+	Admins []string
+	// SenderLimit and ChatLimit limit agent requests per sender and per
+	// chat; the zero Limit allows everything
+	SenderLimit ratelimit.Limit
+	ChatLimit   ratelimit.Limit
+	// RunTimeout bounds the wait for an agent reply, 0 uses the client
+	// default; AgentRunTimeouts overrides it for individual agents
+	RunTimeout       time.Duration
+	AgentRunTimeouts map[string]time.Duration
+	// Progress is the default progress mode of chats, empty uses
+	// ProgressSummary
+	Progress string
 }
 
 // messageCache stores seen message IDs to prevent duplicate processing
@@ -36,12 +123,16 @@ type messageCache struct {
 	cache map[string]time.Time
 	mu    sync.RWMutex
 	ttl   time.Duration
+
+	stopOnce sync.Once
+	done     chan struct{}
 }
 
 func newMessageCache(ttl time.Duration) *messageCache {
 	mc := &messageCache{
 		cache: make(map[string]time.Time),
 		ttl:   ttl,
+		done:  make(chan struct{}),
 	}
 
 	// Start cleanup goroutine
@@ -69,7 +160,13 @@ func (mc *messageCache) cleanup() {
 	ticker := time.NewTicker(1 * time.Minute)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-mc.done:
+			return
+		case <-ticker.C:
+		}
+
 		mc.mu.Lock()
 		now := time.Now()
 		for id, timestamp := range mc.cache {
@@ -81,98 +178,353 @@ func (mc *messageCache) cleanup() {
 	}
 }
 
+// stop ends the cleanup goroutine
+func (mc *messageCache) stop() {
+	mc.stopOnce.Do(func() { close(mc.done) })
+}
+
 // NewBridge creates a new bridge
-func NewBridge(feishuClient *feishu.Client, clawdbotClient *clawdbot.Client, thinkingMs int) *Bridge {
-	return &Bridge{
-		feishuClient:   feishuClient,
-		clawdbotClient: clawdbotClient,
-		thinkingMs:     thinkingMs,
-		seenMessages:   newMessageCache(10 * time.Minute),
+func NewBridge(clawdbotClient *clawdbot.Client, opts Options) (*Bridge, error) {
+	settingsPath, accessPath, limitsPath := "", "", ""
+	if opts.StateDir != "" {
+		settingsPath = filepath.Join(opts.StateDir, "chats.json")
+		accessPath = filepath.Join(opts.StateDir, "access.json")
+		limitsPath = filepath.Join(opts.StateDir, "ratelimits.json")
+	}
+	settings, err := loadSettingsStore(settingsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Staged files must be readable by the agent, which runs on this host
+	filesDir := filepath.Join(os.TempDir(), "clawdbot-bridge-files")
+	if opts.StateDir != "" {
+		filesDir = filepath.Join(opts.StateDir, "files")
+	}
+
+	replyMode := opts.ReplyMode
+	if replyMode == "" {
+		replyMode = ReplyModeQuote
+	}
+	sessionStrategy := opts.SessionStrategy
+	if sessionStrategy == "" {
+		sessionStrategy = SessionPerChat
+	}
+	if err := ValidateSessionStrategy(sessionStrategy); err != nil {
+		return nil, err
 	}
+	triggerMode := opts.TriggerMode
+	if triggerMode == "" {
+		triggerMode = TriggerHeuristic
+	}
+	if !ValidTriggerMode(triggerMode) {
+		return nil, fmt.Errorf("invalid trigger mode %q", triggerMode)
+	}
+	progressMode := opts.Progress
+	if progressMode == "" {
+		progressMode = ProgressSummary
+	}
+	if !ValidProgressMode(progressMode) {
+		return nil, fmt.Errorf("invalid progress mode %q", progressMode)
+	}
+	triggers, err := newTriggerRules(opts.Triggers)
+	if err != nil {
+		return nil, err
+	}
+	access, err := newAccessControl(accessPath)
+	if err != nil {
+		return nil, err
+	}
+	limiter, err := ratelimit.New(limitsPath)
+	if err != nil {
+		return nil, err
+	}
+	var seen *seenStore
+	if opts.StateDir != "" {
+		if seen, err = openSeenStore(filepath.Join(opts.StateDir, "seen.log"), seenTTL); err != nil {
+			return nil, err
+		}
+	}
+	admins := make(map[string]bool, len(opts.Admins))
+	for _, id := range opts.Admins {
+		admins[id] = true
+	}
+
+	runCtx, cancelRuns := context.WithCancel(context.Background())
+
+	b := &Bridge{
+		runCtx:           runCtx,
+		cancelRuns:       cancelRuns,
+		messengers:       make(map[string]im.Messenger),
+		clawdbotClient:   clawdbotClient,
+		thinkingMs:       opts.ThinkingMs,
+		streamIntervalMs: opts.StreamIntervalMs,
+		seenMessages:     newMessageCache(10 * time.Minute),
+		seenStore:        seen,
+		runs:             newRunQueue(opts.MaxConcurrentRuns),
+		settings:         settings,
+		attachments:      newAttachmentPolicy(opts),
+		files:            newFileStager(filesDir, opts.MaxFileBytes),
+		images:           newImageHolder(),
+		codeFileBytes:    opts.CodeFileBytes,
+		replyMode:        replyMode,
+		sessionStrategy:  sessionStrategy,
+		headers:          headerTemplates{group: opts.GroupHeader, p2p: opts.P2PHeader},
+		names:            newNameCache(),
+		triggers:         triggers,
+		access:           access,
+		limiter:          limiter,
+		senderLimit:      opts.SenderLimit,
+		chatLimit:        opts.ChatLimit,
+		limitNotices:     limitNotices{sent: make(map[string]time.Time)},
+		triggerMode:      triggerMode,
+		progressMode:     progressMode,
+		admins:           admins,
+		active:           newActiveRuns(),
+		runTimeout:       opts.RunTimeout,
+		agentRunTimeouts: opts.AgentRunTimeouts,
+		commands:         make(map[string]Command),
+	}
+	b.registerBuiltinCommands()
+
+	return b, nil
 }
 
-// SetFeishuClient sets the Feishu client after construction
-func (b *Bridge) SetFeishuClient(client *feishu.Client) {
-	b.feishuClient = client
+// AddMessenger registers an IM platform adapter. Call it before Run.
+func (b *Bridge) AddMessenger(m im.Messenger) {
+	b.messengers[m.Platform()] = m
 }
 
-// HandleMessage processes a message from Feishu
-func (b *Bridge) HandleMessage(msg *feishu.Message) error {
+// Run starts every messenger and blocks until ctx is done or one of them fails
+func (b *Bridge) Run(ctx context.Context) error {
+	if len(b.messengers) == 0 {
+		return fmt.Errorf("no messenger configured")
+	}
+
+	errChan := make(chan error, len(b.messengers))
+	for _, m := range b.messengers {
+		go func(m im.Messenger) {
+			if err := m.Start(ctx, b.HandleMessage); err != nil {
+				errChan <- fmt.Errorf("%s: %w", m.Platform(), err)
+			}
+		}(m)
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-errChan:
+		return err
+	}
+}
+
+// Shutdown stops accepting messages and waits for queued and running agent
+// runs to finish. Runs still going when ctx is done are cancelled, and their
+// replies say that the service is restarting.
+func (b *Bridge) Shutdown(ctx context.Context) error {
+	b.closing.Store(true)
+	b.seenMessages.stop()
+
+	running, queued := b.runs.stats()
+	if running+queued > 0 {
+		log.Printf("[Bridge] Waiting for %d running and %d queued requests", running, queued)
+	}
+
+	idle := make(chan struct{})
+	go func() {
+		b.runs.wait()
+		close(idle)
+	}()
+
+	var err error
+	select {
+	case <-idle:
+	case <-ctx.Done():
+		err = ctx.Err()
+		log.Printf("[Bridge] Drain timed out, cancelling remaining requests")
+		b.cancelRuns()
+		// Cancelled runs still post their final message
+		select {
+		case <-idle:
+		case <-time.After(finalEditTimeout):
+		}
+	}
+
+	b.cancelRuns()
+	if b.seenStore != nil {
+		b.seenStore.close()
+	}
+	if err := b.limiter.Close(); err != nil {
+		log.Printf("[Bridge] Failed to save rate limit counters: %v", err)
+	}
+	return err
+}
+
+// HandleMessage processes a message from any messenger
+func (b *Bridge) HandleMessage(msg *im.Message) error {
+	m, ok := b.messengers[msg.Platform]
+	if !ok {
+		return fmt.Errorf("no messenger for platform %q", msg.Platform)
+	}
+
+	if b.closing.Load() {
+		log.Printf("[Bridge] Shutting down, dropping message %s", msg.MessageID)
+		return nil
+	}
+
 	// Check for duplicates
-	if msg.MessageID != "" && b.seenMessages.has(msg.MessageID) {
+	if b.isDuplicate(msg) {
 		log.Printf("[Bridge] Skipping duplicate message: %s", msg.MessageID)
 		return nil
 	}
 
-	// Mark as seen
-	if msg.MessageID != "" {
-		b.seenMessages.add(msg.MessageID)
+	// Access rules apply before anything in the message is used
+	if !b.allowed(m, msg) {
+		return nil
+	}
+
+	sessionKey := b.sessionKeyFor(msg)
+
+	// Files wait for a follow-up message that says what to do with them
+	if downloader, ok := m.(im.Downloader); ok && hasResource(msg, im.ResourceFile) {
+		names := b.files.stage(downloader, sessionKey, msg)
+		log.Printf("[Bridge] Staging files from %s: %v", msg.ChatID, names)
+		if msg.ChatType == im.ChatTypeP2P {
+			go b.reply(m, msg, fmt.Sprintf("已收到文件 %s，请告诉我需要如何处理。", strings.Join(names, "、")))
+		}
 	}
 
 	// Clean up message text
 	text := msg.Content
-	text = removeMentions(text)
+	text = resolveMentions(text, msg.Mentions)
 	text = strings.TrimSpace(text)
 
 	if text == "" {
 		return nil
 	}
 
+	// Chat commands bypass the group trigger rules and the agent queue
+	if name, args, ok := parseCommand(text); ok {
+		if cmd, ok := b.lookupCommand(name); ok {
+			log.Printf("[Bridge] Running command /%s in %s", name, msg.ChatID)
+			go b.runCommand(m, cmd, &CommandRequest{
+				ChatKey:    chatKey(msg),
+				ChatID:     msg.ChatID,
+				SessionKey: sessionKey,
+				Args:       args,
+				Message:    msg,
+			})
+			return nil
+		}
+	}
+
 	// For group chats, check if we should respond
-	if msg.ChatType == "group" {
-		if !shouldRespondInGroup(text, msg.Mentions) {
+	if msg.ChatType == im.ChatTypeGroup {
+		if !b.triggers.shouldRespond(b.triggerModeFor(chatKey(msg)), text, msg.Mentions) {
+			// Images wait for the sender's next message that asks about them
+			if _, ok := m.(im.Downloader); ok && hasResource(msg, im.ResourceImage) {
+				if n := b.images.hold(msg); n > 0 {
+					log.Printf("[Bridge] Holding %d images from %s for the sender's next question", n, msg.ChatID)
+					return nil
+				}
+			}
 			log.Printf("[Bridge] Skipping group message (no trigger): %s", text)
 			return nil
 		}
 	}
 
+	if !b.admit(m, msg) {
+		return nil
+	}
+
 	log.Printf("[Bridge] Processing message from %s: %s", msg.ChatID, text)
 
-	// Process asynchronously
-	go b.processMessage(msg.ChatID, text)
+	// Link files and held images sent shortly before this message
+	files := b.files.take(sessionKey)
+	images := b.images.take(msg)
+
+	// Process asynchronously, in order within the session
+	b.runs.enqueue(sessionKey, func() {
+		b.processMessage(m, msg, sessionKey, text, files, images)
+	})
 
 	return nil
 }
 
-func (b *Bridge) processMessage(chatID, text string) {
-	var placeholderID string
-	var done bool
-	var mu sync.Mutex
+func (b *Bridge) processMessage(m im.Messenger, msg *im.Message, sessionKey, text string, files []*stagedFile, images []heldImage) {
+	// Messages are sent with their own context so replies still go out
+	// after runCtx is cancelled
+	ctx := context.Background()
+	runCtx := b.runCtx
+
+	// Requests still queued at shutdown never start
+	if runCtx.Err() != nil {
+		b.reply(m, msg, shutdownNotice)
+		return
+	}
+
+	attachments, err := b.loadAttachments(runCtx, m, msg, images)
+	if err != nil {
+		log.Printf("[Bridge] Failed to load attachments of %s: %v", msg.MessageID, err)
+		b.reply(m, msg, fmt.Sprintf("（附件无法处理）%v", err))
+		return
+	}
+	if len(files) > 0 {
+		text += "\n" + fileReferences(files)
+	}
+	if header := b.messageHeader(m, msg); header != "" {
+		text = header + "\n" + text
+	}
+
+	stream := newReplyStream(b, m, msg)
+	stream.start()
 
 	// Show "thinking..." if response takes too long
 	var timer *time.Timer
 	if b.thinkingMs > 0 {
-		timer = time.AfterFunc(time.Duration(b.thinkingMs)*time.Millisecond, func() {
-			mu.Lock()
-			defer mu.Unlock()
-
-			if done {
-				return
-			}
-
-			msgID, err := b.feishuClient.SendMessage(chatID, "正在思考…")
-			if err != nil {
-				log.Printf("[Bridge] Failed to send thinking message: %v", err)
-				return
-			}
-			placeholderID = msgID
-		})
+		timer = time.AfterFunc(time.Duration(b.thinkingMs)*time.Millisecond, stream.showThinking)
 	}
 
-	// Ask ClawdBot
-	sessionKey := fmt.Sprintf("feishu:%s", chatID)
-	reply, err := b.clawdbotClient.AskClawdbot(text, sessionKey, nil)
-
-	// Mark as done
-	mu.Lock()
-	done = true
-	mu.Unlock()
+	// The run can be stopped from the chat with /stop or the stop button
+	askCtx, cancel := context.WithCancel(runCtx)
+	defer cancel()
+	run := &activeRun{sessionKey: sessionKey, msg: msg, stream: stream, cancel: cancel}
+	b.active.add(run)
+	defer b.active.remove(run)
+
+	// Ask ClawdBot, streaming partial replies into the chat
+	agentID := b.agentFor(chatKey(msg))
+	reply, err := b.clawdbotClient.Ask(askCtx, clawdbot.AskRequest{
+		Text:        text,
+		SessionKey:  sessionKey,
+		AgentID:     agentID,
+		Attachments: attachments,
+		Timeout:     b.runTimeoutFor(agentID),
+		OnStart:     func(runID string) { b.runStarted(run, runID) },
+	}, stream.onProgress)
 
 	if timer != nil {
 		timer.Stop()
 	}
 
-	if err != nil {
+	// Stop streaming; from here on the reply message is ours alone
+	placeholderID := stream.finish()
+
+	switch {
+	case run.isStopped():
+		// Keep what the agent wrote before it was stopped
+		if err != nil {
+			reply = stream.partial()
+		}
+		reply = strings.TrimSpace(reply + "\n\n" + cancelledNotice)
+		log.Printf("[Bridge] Run for %s stopped from the chat", msg.MessageID)
+	case err != nil && runCtx.Err() != nil:
+		reply = shutdownNotice
+		log.Printf("[Bridge] Run for %s cancelled by shutdown", msg.MessageID)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, finalEditTimeout)
+		defer cancel()
+	case err != nil:
 		reply = fmt.Sprintf("（系统出错）%v", err)
 		log.Printf("[Bridge] Error from ClawdBot: %v", err)
 	}
@@ -182,71 +534,100 @@ func (b *Bridge) processMessage(chatID, text string) {
 	log.Printf("[Bridge] ClawdBot raw reply: %q", reply)
 
 	// Check for NO_REPLY
-	if reply == "" || reply == "NO_REPLY" {
+	if reply == "" || reply == noReplyToken {
 		log.Printf("[Bridge] Received NO_REPLY, not sending message")
 
 		// Delete thinking placeholder if it exists
 		if placeholderID != "" {
-			if err := b.feishuClient.DeleteMessage(placeholderID); err != nil {
-				log.Printf("[Bridge] Failed to delete placeholder: %v", err)
-			}
+			b.deletePlaceholder(ctx, m, placeholderID)
 		}
 		return
 	}
 
-	// Send or update message
-	mu.Lock()
-	currentPlaceholder := placeholderID
-	mu.Unlock()
+	if tools := stream.toolSummary(); tools != "" {
+		reply = tools + "\n\n" + reply
+	}
+	b.deliverReply(ctx, m, msg, placeholderID, reply)
+}
+
+// deliverReply sends the final reply, split into numbered parts when it is
+// too long for one message. The first part replaces the placeholder.
+func (b *Bridge) deliverReply(ctx context.Context, m im.Messenger, msg *im.Message, placeholderID, reply string) {
+	chatID := msg.ChatID
+
+	var files []codeFile
+	if _, ok := m.(im.FileSender); ok {
+		reply, files = extractCodeFiles(reply, b.codeFileBytes)
+	}
 
-	if currentPlaceholder != "" {
-		// Update existing "thinking..." message
-		if err := b.feishuClient.UpdateMessage(currentPlaceholder, reply); err != nil {
-			log.Printf("[Bridge] Failed to update message, sending new: %v", err)
-			// Fall back to sending new message
-			if _, err := b.feishuClient.SendMessage(chatID, reply); err != nil {
-				log.Printf("[Bridge] Failed to send message: %v", err)
+	parts := []string{reply}
+	if limit := messageLimit(m); limit > 0 {
+		parts = numberParts(splitReply(reply, limit-partHeaderReserve))
+	}
+	if len(parts) > 1 {
+		log.Printf("[Bridge] Splitting reply to %s into %d parts", chatID, len(parts))
+	}
+
+	first := true
+	if placeholderID != "" {
+		// Final flush of the placeholder or streamed message
+		if updater, ok := m.(im.Updater); ok {
+			err := updater.UpdateMessage(ctx, placeholderID, parts[0])
+			if err == nil {
+				log.Printf("[Bridge] Updated message in %s", chatID)
+				first = false
+			} else {
+				log.Printf("[Bridge] Failed to update message, sending new: %v", err)
 			}
 		} else {
-			log.Printf("[Bridge] Updated message in %s", chatID)
-		}
-	} else {
-		// Send new message
-		if _, err := b.feishuClient.SendMessage(chatID, reply); err != nil {
-			log.Printf("[Bridge] Failed to send message: %v", err)
-		} else {
-			log.Printf("[Bridge] Sent message to %s", chatID)
+			// The placeholder cannot become the reply, so replace it
+			b.deletePlaceholder(ctx, m, placeholderID)
 		}
 	}
-}
-
-// shouldRespondInGroup determines if the bot should respond in a group chat
-func shouldRespondInGroup(text string, mentions []feishu.Mention) bool {
-	// Always respond if mentioned
-	if len(mentions) > 0 {
-		return true
+	if !first {
+		parts = parts[1:]
 	}
 
-	lowerText := strings.ToLower(text)
-
-	// Question marks
-	if strings.HasSuffix(text, "?") || strings.HasSuffix(text, "？") {
-		return true
+	// Send new messages
+	for _, part := range parts {
+		if _, err := b.sendReply(ctx, m, msg, part); err != nil {
+			log.Printf("[Bridge] Failed to send message: %v", err)
+			return
+		}
+		log.Printf("[Bridge] Sent message to %s", chatID)
 	}
 
-	if questionWordPattern.MatchString(lowerText) {
-		return true
+	for _, f := range files {
+		if _, err := m.(im.FileSender).SendFile(ctx, chatID, f.name, f.data); err != nil {
+			log.Printf("[Bridge] Failed to send code file %s: %v", f.name, err)
+		}
 	}
+}
 
-	if containsAny(text, actionVerbs) {
-		return true
+// hasResource reports whether msg carries a resource of the given type
+func hasResource(msg *im.Message, resourceType string) bool {
+	for _, res := range msg.Resources {
+		if res.Type == resourceType {
+			return true
+		}
 	}
+	return false
+}
 
-	if botTriggerPattern.MatchString(lowerText) {
-		return true
+// deletePlaceholder removes a placeholder message if the platform allows it
+func (b *Bridge) deletePlaceholder(ctx context.Context, m im.Messenger, messageID string) {
+	deleter, ok := m.(im.Deleter)
+	if !ok {
+		return
+	}
+	if err := deleter.DeleteMessage(ctx, messageID); err != nil {
+		log.Printf("[Bridge] Failed to delete placeholder: %v", err)
 	}
+}
 
-	return false
+// chatKey identifies a chat across platforms, e.g. "feishu:oc_xxx"
+func chatKey(msg *im.Message) string {
+	return fmt.Sprintf("%s:%s", msg.Platform, msg.ChatID)
 }
 
 func containsAny(text string, keywords []string) bool {
@@ -259,6 +640,33 @@ func containsAny(text string, keywords []string) bool {
 	return false
 }
 
+// mentionsBot reports whether any of the mentions addresses the bot
+func mentionsBot(mentions []im.Mention) bool {
+	for _, mention := range mentions {
+		if mention.Bot {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveMentions drops the bot's own mention from text and replaces the
+// placeholders of other mentions with their names, so the agent knows who
+// else was addressed
+func resolveMentions(text string, mentions []im.Mention) string {
+	for _, mention := range mentions {
+		if mention.Key == "" {
+			continue
+		}
+		name := ""
+		if !mention.Bot && mention.Name != "" {
+			name = "@" + mention.Name
+		}
+		text = strings.ReplaceAll(text, mention.Key, name)
+	}
+	return removeMentions(text)
+}
+
 // removeMentions removes @mention patterns from text
 func removeMentions(text string) string {
 	return mentionPattern.ReplaceAllString(text, "")
@@ -1,26 +1,37 @@
 package bridge
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
 
-	"github.com/wy51ai/moltbotCNAPP/internal/feishu"
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
 )
 
-func TestShouldRespondInGroup(t *testing.T) {
+func TestShouldRespondHeuristic(t *testing.T) {
 	testCases := []struct {
 		name     string
 		text     string
-		mentions []feishu.Mention
+		mentions []im.Mention
 		want     bool
 	}{
 		{
 			name: "responds when mentioned",
 			text: "hello there",
-			mentions: []feishu.Mention{
-				{ID: "user-1"},
+			mentions: []im.Mention{
+				{ID: "ou_bot", Bot: true},
 			},
 			want: true,
 		},
+		{
+			name: "ignores mentions of other members",
+			text: "hello there",
+			mentions: []im.Mention{
+				{ID: "ou_other"},
+			},
+			want: false,
+		},
 		{
 			name: "responds to trailing question mark",
 			text: "can you help?",
@@ -53,10 +64,14 @@ func TestShouldRespondInGroup(t *testing.T) {
 		},
 	}
 
+	rules, err := newTriggerRules(TriggerRules{})
+	if err != nil {
+		t.Fatal(err)
+	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			if got := shouldRespondInGroup(tc.text, tc.mentions); got != tc.want {
-				t.Fatalf("shouldRespondInGroup(%q) = %v, want %v", tc.text, got, tc.want)
+			if got := rules.shouldRespond(TriggerHeuristic, tc.text, tc.mentions); got != tc.want {
+				t.Fatalf("shouldRespond(%q) = %v, want %v", tc.text, got, tc.want)
 			}
 		})
 	}
@@ -70,3 +85,66 @@ func TestRemoveMentions(t *testing.T) {
 		t.Fatalf("removeMentions() = %q, want %q", got, want)
 	}
 }
+
+func TestResolveMentions(t *testing.T) {
+	mentions := []im.Mention{
+		{Key: "@_user_1", ID: "ou_bot", Name: "助手", Bot: true},
+		{Key: "@_user_2", ID: "ou_b", Name: "李四"},
+	}
+	got := resolveMentions("@_user_1 把这个转给 @_user_2 处理", mentions)
+	want := " 把这个转给 @李四 处理"
+
+	if got != want {
+		t.Fatalf("resolveMentions() = %q, want %q", got, want)
+	}
+}
+
+func TestShutdownDrainsRuns(t *testing.T) {
+	b, err := NewBridge(nil, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	finished := make(chan struct{})
+	b.runs.enqueue("s1", func() {
+		time.Sleep(20 * time.Millisecond)
+		close(finished)
+	})
+	if err := b.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error: %v", err)
+	}
+	select {
+	case <-finished:
+	default:
+		t.Fatal("Shutdown() returned before the run finished")
+	}
+
+	m := &fakeReplier{}
+	b.AddMessenger(m)
+	if err := b.HandleMessage(&im.Message{Platform: "fake", MessageID: "om_1", ChatID: "oc_1", ChatType: im.ChatTypeP2P, Content: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if running, queued := b.runs.stats(); running+queued != 0 {
+		t.Fatal("message accepted after Shutdown")
+	}
+}
+
+func TestShutdownCancelsRunsAfterTimeout(t *testing.T) {
+	b, err := NewBridge(nil, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.runs.enqueue("s1", func() {
+		<-b.runCtx.Done()
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := b.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown() error = %v, want context.DeadlineExceeded", err)
+	}
+	if running, _ := b.runs.stats(); running != 0 {
+		t.Fatal("run still going after Shutdown")
+	}
+}
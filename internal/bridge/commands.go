@@ -0,0 +1,393 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+// Command is a chat command such as /reset
+type Command struct {
+	// Name is matched case-insensitively without the leading slash
+	Name        string
+	Usage       string
+	Description string
+	Handler     CommandHandler
+}
+
+// CommandHandler runs a command and returns the text to reply with
+type CommandHandler func(b *Bridge, req *CommandRequest) (string, error)
+
+// CommandRequest carries the context of one command invocation
+type CommandRequest struct {
+	// ChatKey identifies the chat across platforms, e.g. "feishu:oc_xxx"
+	ChatKey    string
+	ChatID     string
+	SessionKey string
+	Args       string
+	Message    *im.Message
+}
+
+// RegisterCommand adds or replaces a chat command
+func (b *Bridge) RegisterCommand(cmd Command) {
+	name := strings.ToLower(cmd.Name)
+
+	b.commandsMu.Lock()
+	defer b.commandsMu.Unlock()
+
+	if _, exists := b.commands[name]; !exists {
+		b.commandOrder = append(b.commandOrder, name)
+	}
+	b.commands[name] = cmd
+}
+
+// lookupCommand returns the command registered under name
+func (b *Bridge) lookupCommand(name string) (Command, bool) {
+	b.commandsMu.RLock()
+	defer b.commandsMu.RUnlock()
+
+	cmd, ok := b.commands[strings.ToLower(name)]
+	return cmd, ok
+}
+
+// listCommands returns commands in registration order
+func (b *Bridge) listCommands() []Command {
+	b.commandsMu.RLock()
+	defer b.commandsMu.RUnlock()
+
+	cmds := make([]Command, 0, len(b.commandOrder))
+	for _, name := range b.commandOrder {
+		cmds = append(cmds, b.commands[name])
+	}
+	return cmds
+}
+
+// parseCommand splits "/name args" into its parts
+func parseCommand(text string) (name, args string, ok bool) {
+	if !strings.HasPrefix(text, "/") {
+		return "", "", false
+	}
+
+	fields := strings.SplitN(text[1:], " ", 2)
+	name = strings.TrimSpace(fields[0])
+	if name == "" {
+		return "", "", false
+	}
+	if len(fields) == 2 {
+		args = strings.TrimSpace(fields[1])
+	}
+
+	return name, args, true
+}
+
+// runCommand executes a command and replies in the chat
+func (b *Bridge) runCommand(m im.Messenger, cmd Command, req *CommandRequest) {
+	reply, err := cmd.Handler(b, req)
+	if err != nil {
+		log.Printf("[Bridge] Command /%s failed: %v", cmd.Name, err)
+		reply = fmt.Sprintf("（命令执行失败）%v", err)
+	}
+
+	if reply == "" {
+		return
+	}
+
+	if _, err := b.sendReply(context.Background(), m, req.Message, reply); err != nil {
+		log.Printf("[Bridge] Failed to send command reply: %v", err)
+	}
+}
+
+// registerBuiltinCommands installs the default command set
+func (b *Bridge) registerBuiltinCommands() {
+	b.RegisterCommand(Command{
+		Name:        "help",
+		Usage:       "/help",
+		Description: "显示可用命令",
+		Handler:     helpCommand,
+	})
+	b.RegisterCommand(Command{
+		Name:        "reset",
+		Usage:       "/reset",
+		Description: "清空当前会话，开始新的对话",
+		Handler:     resetCommand,
+	})
+	b.RegisterCommand(Command{
+		Name:        "stop",
+		Usage:       "/stop",
+		Description: "停止当前会话中正在运行的请求",
+		Handler:     stopCommand,
+	})
+	b.RegisterCommand(Command{
+		Name:        "status",
+		Usage:       "/status",
+		Description: "查看网关连接和队列状态",
+		Handler:     statusCommand,
+	})
+	b.RegisterCommand(Command{
+		Name:        "agent",
+		Usage:       "/agent [id|default]",
+		Description: "查看或切换当前会话使用的 Agent（仅管理员可切换）",
+		Handler:     agentCommand,
+	})
+	b.RegisterCommand(Command{
+		Name:        "reply",
+		Usage:       "/reply [message|reply|thread|default]",
+		Description: "查看或设置本聊天的回复方式：新消息、引用回复或话题回复（仅管理员可修改）",
+		Handler:     replyCommand,
+	})
+	b.RegisterCommand(Command{
+		Name:        "session",
+		Usage:       "/session [chat|sender|thread|模板|default]",
+		Description: "查看或设置本聊天的会话划分：整个聊天、每位成员或每个话题共用一个会话（仅管理员可修改）",
+		Handler:     sessionCommand,
+	})
+	b.RegisterCommand(Command{
+		Name:        "trigger",
+		Usage:       "/trigger [mention_only|heuristic|always|never|default]",
+		Description: "查看或设置群聊中触发回复的方式（仅管理员可修改）",
+		Handler:     triggerCommand,
+	})
+	b.RegisterCommand(Command{
+		Name:        "progress",
+		Usage:       "/progress [off|summary|detailed|default]",
+		Description: "查看或设置本聊天显示工具调用进度的方式：不显示、简要或详细（仅管理员可修改）",
+		Handler:     progressCommand,
+	})
+	b.RegisterCommand(Command{
+		Name:        "quota",
+		Usage:       "/quota [用户ID|reset [用户ID]]",
+		Description: "查看今天的使用次数，管理员可查看或重置他人及本聊天的额度",
+		Handler:     quotaCommand,
+	})
+}
+
+func helpCommand(b *Bridge, req *CommandRequest) (string, error) {
+	var sb strings.Builder
+	sb.WriteString("可用命令：")
+	for _, cmd := range b.listCommands() {
+		sb.WriteString(fmt.Sprintf("\n%s  %s", cmd.Usage, cmd.Description))
+	}
+	return sb.String(), nil
+}
+
+func resetCommand(b *Bridge, req *CommandRequest) (string, error) {
+	if err := b.clawdbotClient.ResetSession(b.runCtx, req.SessionKey); err != nil {
+		return "", err
+	}
+	return "会话已重置，我们重新开始吧。", nil
+}
+
+func statusCommand(b *Bridge, req *CommandRequest) (string, error) {
+	gateway := "已连接"
+	if !b.clawdbotClient.Connected() {
+		gateway = "未连接"
+	}
+	running, queued := b.runs.stats()
+
+	return fmt.Sprintf("网关：%s\nAgent：%s\n回复方式：%s\n运行中：%d\n排队中：%d",
+		gateway, b.agentFor(req.ChatKey), replyModeNames[b.replyModeFor(req.ChatKey)], running, queued), nil
+}
+
+func agentCommand(b *Bridge, req *CommandRequest) (string, error) {
+	if req.Args == "" {
+		return fmt.Sprintf("当前 Agent：%s", b.agentFor(req.ChatKey)), nil
+	}
+	if !b.isAdmin(req.Message) {
+		return adminOnly(req.Message, "切换 Agent"), nil
+	}
+
+	if req.Args == "default" {
+		if err := b.settings.update(req.ChatKey, func(s *chatSettings) { s.AgentID = "" }); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("已恢复默认 Agent：%s", b.clawdbotClient.AgentID()), nil
+	}
+
+	agentID := strings.Fields(req.Args)[0]
+	if err := b.settings.update(req.ChatKey, func(s *chatSettings) { s.AgentID = agentID }); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("已切换到 Agent：%s", agentID), nil
+}
+
+// replyModeNames are the reply modes as shown to users
+var replyModeNames = map[string]string{
+	ReplyModeMessage: "新消息",
+	ReplyModeQuote:   "引用回复",
+	ReplyModeThread:  "话题回复",
+}
+
+func replyCommand(b *Bridge, req *CommandRequest) (string, error) {
+	mode := strings.ToLower(req.Args)
+	switch {
+	case mode == "":
+		return fmt.Sprintf("当前回复方式：%s", replyModeNames[b.replyModeFor(req.ChatKey)]), nil
+	case !b.isAdmin(req.Message):
+		return adminOnly(req.Message, "修改回复方式"), nil
+	case mode == "default":
+		mode = ""
+	case !ValidReplyMode(mode):
+		return "用法：/reply [message|reply|thread|default]", nil
+	}
+
+	if err := b.settings.update(req.ChatKey, func(s *chatSettings) { s.ReplyMode = mode }); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("回复方式已设置为：%s", replyModeNames[b.replyModeFor(req.ChatKey)]), nil
+}
+
+// sessionStrategyNames are the session strategies as shown to users
+var sessionStrategyNames = map[string]string{
+	SessionPerChat:   "整个聊天共用一个会话",
+	SessionPerSender: "每位成员独立会话",
+	SessionPerThread: "每个话题独立会话",
+}
+
+// describeSessionStrategy names a strategy, quoting custom templates as is
+func describeSessionStrategy(strategy string) string {
+	if name, ok := sessionStrategyNames[strategy]; ok {
+		return name
+	}
+	return "自定义模板 " + strategy
+}
+
+func sessionCommand(b *Bridge, req *CommandRequest) (string, error) {
+	strategy := req.Args
+	if strategy == "" {
+		return fmt.Sprintf("当前会话划分：%s\n当前会话：%s",
+			describeSessionStrategy(b.sessionStrategyFor(req.ChatKey)), req.SessionKey), nil
+	}
+	if !b.isAdmin(req.Message) {
+		return adminOnly(req.Message, "修改会话划分"), nil
+	}
+
+	switch strategy {
+	case "default":
+		strategy = ""
+	default:
+		if err := ValidateSessionStrategy(strategy); err != nil {
+			return fmt.Sprintf("无效的会话划分：%v\n用法：/session [chat|sender|thread|default]，或使用包含 {platform} 和 {chat_id} 的模板，可另加 {chat_type}、{sender_id}、{thread_id}，占位符之间只能用 : / - _ . 分隔", err), nil
+		}
+	}
+
+	if err := b.settings.update(req.ChatKey, func(s *chatSettings) { s.SessionStrategy = strategy }); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("会话划分已设置为：%s", describeSessionStrategy(b.sessionStrategyFor(req.ChatKey))), nil
+}
+
+// triggerModeNames are the trigger modes as shown to users
+var triggerModeNames = map[string]string{
+	TriggerMentionOnly: "仅 @机器人 时回复",
+	TriggerHeuristic:   "@机器人或识别为提问时回复",
+	TriggerAlways:      "回复所有消息",
+	TriggerNever:       "不回复（仅响应命令）",
+}
+
+func triggerCommand(b *Bridge, req *CommandRequest) (string, error) {
+	mode := strings.ToLower(req.Args)
+	switch {
+	case mode == "":
+		return fmt.Sprintf("当前触发方式：%s", triggerModeNames[b.triggerModeFor(req.ChatKey)]), nil
+	case !b.isAdmin(req.Message):
+		return adminOnly(req.Message, "修改触发方式"), nil
+	case mode == "default":
+		mode = ""
+	case !ValidTriggerMode(mode):
+		return "用法：/trigger [mention_only|heuristic|always|never|default]", nil
+	}
+
+	if err := b.settings.update(req.ChatKey, func(s *chatSettings) { s.TriggerMode = mode }); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("触发方式已设置为：%s", triggerModeNames[b.triggerModeFor(req.ChatKey)]), nil
+}
+
+// progressModeNames are the progress modes as shown to users
+var progressModeNames = map[string]string{
+	ProgressOff:      "不显示",
+	ProgressSummary:  "简要",
+	ProgressDetailed: "详细",
+}
+
+func progressCommand(b *Bridge, req *CommandRequest) (string, error) {
+	mode := strings.ToLower(req.Args)
+	switch {
+	case mode == "":
+		return fmt.Sprintf("当前工具调用进度：%s", progressModeNames[b.progressModeFor(req.ChatKey)]), nil
+	case !b.isAdmin(req.Message):
+		return adminOnly(req.Message, "修改工具调用进度"), nil
+	case mode == "default":
+		mode = ""
+	case !ValidProgressMode(mode):
+		return "用法：/progress [off|summary|detailed|default]", nil
+	}
+
+	if err := b.settings.update(req.ChatKey, func(s *chatSettings) { s.Progress = mode }); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("工具调用进度已设置为：%s", progressModeNames[b.progressModeFor(req.ChatKey)]), nil
+}
+
+func quotaCommand(b *Bridge, req *CommandRequest) (string, error) {
+	msg := req.Message
+	fields := strings.Fields(req.Args)
+
+	if len(fields) > 0 && fields[0] == "reset" {
+		if !b.isAdmin(msg) {
+			return adminOnly(msg, "重置额度"), nil
+		}
+		if len(fields) > 1 {
+			if err := b.limiter.Reset(senderLimitKey(msg.Platform, fields[1])); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("已重置用户 %s 的额度。", fields[1]), nil
+		}
+		if err := b.limiter.Reset(req.ChatKey); err != nil {
+			return "", err
+		}
+		return "已重置本聊天的额度。", nil
+	}
+
+	senderID := msg.Sender.ID
+	if len(fields) > 0 {
+		if !b.isAdmin(msg) {
+			return adminOnly(msg, "查看他人的额度"), nil
+		}
+		senderID = fields[0]
+	}
+	sender := b.limiter.Usage(senderLimitKey(msg.Platform, senderID), b.senderLimit)
+	chat := b.limiter.Usage(req.ChatKey, b.chatLimit)
+
+	return fmt.Sprintf("用户 %s 今天已使用：%s\n本聊天今天已使用：%s",
+		senderID, formatQuota(sender.Used, b.senderLimit.Daily), formatQuota(chat.Used, b.chatLimit.Daily)), nil
+}
+
+// isAdmin reports whether the sender of msg may change administrative
+// settings. Nobody may when no admins are configured.
+func (b *Bridge) isAdmin(msg *im.Message) bool {
+	sender := msg.Sender
+	for _, id := range []string{sender.ID, sender.UnionID, sender.UserID} {
+		if id != "" && b.admins[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// adminOnly refuses an administrative action and tells the sender how to
+// become an admin
+func adminOnly(msg *im.Message, action string) string {
+	return fmt.Sprintf("只有管理员可以%s。管理员在配置文件的 admins 中设置，你的用户 ID 是 %s。", action, msg.Sender.ID)
+}
+
+// agentFor returns the agent that serves a chat
+func (b *Bridge) agentFor(chatKey string) string {
+	if agentID := b.settings.get(chatKey).AgentID; agentID != "" {
+		return agentID
+	}
+	return b.clawdbotClient.AgentID()
+}
@@ -0,0 +1,85 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+func TestParseCommand(t *testing.T) {
+	testCases := []struct {
+		text     string
+		wantName string
+		wantArgs string
+		wantOK   bool
+	}{
+		{text: "/reset", wantName: "reset", wantOK: true},
+		{text: "/agent  ops ", wantName: "agent", wantArgs: "ops", wantOK: true},
+		{text: "/", wantOK: false},
+		{text: "reset", wantOK: false},
+		{text: "帮我看看 /etc/hosts", wantOK: false},
+	}
+
+	for _, tc := range testCases {
+		name, args, ok := parseCommand(tc.text)
+		if name != tc.wantName || args != tc.wantArgs || ok != tc.wantOK {
+			t.Fatalf("parseCommand(%q) = (%q, %q, %v), want (%q, %q, %v)",
+				tc.text, name, args, ok, tc.wantName, tc.wantArgs, tc.wantOK)
+		}
+	}
+}
+
+func TestRegisterCommand(t *testing.T) {
+	b, err := NewBridge(nil, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.RegisterCommand(Command{
+		Name:        "Ping",
+		Usage:       "/ping",
+		Description: "检查机器人是否在线",
+		Handler: func(b *Bridge, req *CommandRequest) (string, error) {
+			return "pong " + req.Args, nil
+		},
+	})
+
+	cmd, ok := b.lookupCommand("ping")
+	if !ok {
+		t.Fatal("lookupCommand(ping) not found")
+	}
+	if reply, _ := cmd.Handler(b, &CommandRequest{Args: "x"}); reply != "pong x" {
+		t.Fatalf("ping reply = %q, want %q", reply, "pong x")
+	}
+
+	help, _ := helpCommand(b, &CommandRequest{})
+	for _, usage := range []string{"/help", "/reset", "/status", "/agent", "/ping"} {
+		if !strings.Contains(help, usage) {
+			t.Fatalf("help output missing %s:\n%s", usage, help)
+		}
+	}
+}
+
+func TestAgentCommandAdminOnly(t *testing.T) {
+	b, err := NewBridge(nil, Options{StateDir: t.TempDir(), Admins: []string{"ou_admin"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	member := &CommandRequest{ChatKey: "feishu:oc_1", Args: "ops", Message: &im.Message{Sender: im.Sender{ID: "ou_member"}}}
+	if reply, _ := agentCommand(b, member); !strings.Contains(reply, "管理员") {
+		t.Fatalf("member reply = %q, want a refusal", reply)
+	}
+	if agentID := b.settings.get("feishu:oc_1").AgentID; agentID != "" {
+		t.Fatalf("agent after member command = %q, want unchanged", agentID)
+	}
+
+	admin := &CommandRequest{ChatKey: "feishu:oc_1", Args: "ops", Message: &im.Message{Sender: im.Sender{ID: "ou_admin"}}}
+	if _, err := agentCommand(b, admin); err != nil {
+		t.Fatal(err)
+	}
+	if agentID := b.agentFor("feishu:oc_1"); agentID != "ops" {
+		t.Fatalf("agent after admin command = %q, want ops", agentID)
+	}
+}
@@ -0,0 +1,183 @@
+package bridge
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/fsutil"
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+const (
+	// seenTTL is how long delivered messages are remembered across restarts
+	seenTTL = 24 * time.Hour
+	// seenCompactLines is the minimum log size before compaction
+	seenCompactLines = 1000
+)
+
+// seenStore remembers message and event IDs in an append-only log, so
+// events redelivered after a restart are not answered twice. Each line is
+// "<unix seconds> <key>"; the log is rewritten without expired entries
+// once it has grown to twice its size after the last compaction.
+type seenStore struct {
+	path string
+	ttl  time.Duration
+
+	mu    sync.Mutex
+	seen  map[string]time.Time
+	file  *os.File
+	lines int
+	// compactAt is the log size that triggers the next compaction
+	compactAt int
+}
+
+// openSeenStore loads the log at path and opens it for appending
+func openSeenStore(path string, ttl time.Duration) (*seenStore, error) {
+	s := &seenStore{path: path, ttl: ttl, seen: make(map[string]time.Time)}
+
+	f, err := os.Open(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if err == nil {
+		now := time.Now()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			// A crash may leave a partial last line; skip anything malformed
+			fields := strings.SplitN(scanner.Text(), " ", 2)
+			if len(fields) != 2 {
+				continue
+			}
+			sec, err := strconv.ParseInt(fields[0], 10, 64)
+			if err != nil {
+				continue
+			}
+			if at := time.Unix(sec, 0); now.Sub(at) <= ttl {
+				s.seen[fields[1]] = at
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	}
+
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// add records keys and reports whether any of them was already recorded
+func (s *seenStore) add(keys ...string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, key := range keys {
+		if at, ok := s.seen[key]; ok && now.Sub(at) <= s.ttl {
+			return true
+		}
+	}
+
+	var lines strings.Builder
+	for _, key := range keys {
+		s.seen[key] = now
+		fmt.Fprintf(&lines, "%d %s\n", now.Unix(), key)
+	}
+	if _, err := s.file.WriteString(lines.String()); err != nil {
+		log.Printf("[Bridge] Failed to record seen messages: %v", err)
+	}
+	s.lines += len(keys)
+
+	if s.lines >= s.compactAt {
+		s.prune(now)
+		if err := s.compact(); err != nil {
+			log.Printf("[Bridge] Failed to compact %s: %v", s.path, err)
+		}
+	}
+	return false
+}
+
+// prune drops expired entries. It must be called with s.mu held.
+func (s *seenStore) prune(now time.Time) {
+	for key, at := range s.seen {
+		if now.Sub(at) > s.ttl {
+			delete(s.seen, key)
+		}
+	}
+}
+
+// compact rewrites the log with the live entries and reopens it for
+// appending. It must be called with s.mu held, except from openSeenStore.
+func (s *seenStore) compact() error {
+	var data strings.Builder
+	for key, at := range s.seen {
+		fmt.Fprintf(&data, "%d %s\n", at.Unix(), key)
+	}
+
+	if err := fsutil.WriteFileAtomic(s.path, []byte(data.String()), 0600); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", s.path, err)
+	}
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file = f
+	s.lines = len(s.seen)
+	s.compactAt = 2 * s.lines
+	if s.compactAt < seenCompactLines {
+		s.compactAt = seenCompactLines
+	}
+	return nil
+}
+
+// close closes the log; later adds are kept in memory only
+func (s *seenStore) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.file.Close()
+}
+
+// seenKeys are the dedup keys of a message
+func seenKeys(msg *im.Message) []string {
+	var keys []string
+	if msg.MessageID != "" {
+		keys = append(keys, fmt.Sprintf("%s:msg:%s", msg.Platform, msg.MessageID))
+	}
+	if msg.EventID != "" {
+		keys = append(keys, fmt.Sprintf("%s:event:%s", msg.Platform, msg.EventID))
+	}
+	return keys
+}
+
+// isDuplicate reports whether msg was handled before and records it. The
+// in-memory cache answers recent repeats; the durable store catches
+// redeliveries after a restart.
+func (b *Bridge) isDuplicate(msg *im.Message) bool {
+	keys := seenKeys(msg)
+	if len(keys) == 0 {
+		return false
+	}
+	for _, key := range keys {
+		if b.seenMessages.has(key) {
+			return true
+		}
+	}
+	for _, key := range keys {
+		b.seenMessages.add(key)
+	}
+
+	return b.seenStore != nil && b.seenStore.add(keys...)
+}
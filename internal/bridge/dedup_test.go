@@ -0,0 +1,86 @@
+package bridge
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+func TestDuplicatesAcrossRestart(t *testing.T) {
+	dir := t.TempDir()
+	msg := &im.Message{Platform: "feishu", MessageID: "om_1", EventID: "ev-1"}
+
+	b, err := NewBridge(nil, Options{StateDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.isDuplicate(msg) {
+		t.Fatal("first delivery reported as duplicate")
+	}
+	if !b.isDuplicate(msg) {
+		t.Fatal("second delivery not reported as duplicate")
+	}
+
+	// A new bridge has an empty memory cache but reads the log
+	b, err = NewBridge(nil, Options{StateDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.isDuplicate(&im.Message{Platform: "feishu", MessageID: "om_1"}) {
+		t.Fatal("message redelivered after restart not reported as duplicate")
+	}
+	if !b.isDuplicate(&im.Message{Platform: "feishu", EventID: "ev-1"}) {
+		t.Fatal("event redelivered after restart not reported as duplicate")
+	}
+	if b.isDuplicate(&im.Message{Platform: "feishu", MessageID: "om_2"}) {
+		t.Fatal("new message reported as duplicate")
+	}
+}
+
+func TestSeenStoreCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seen.log")
+	expired := time.Now().Add(-2 * seenTTL).Unix()
+	content := "partial\n" + strconv.FormatInt(expired, 10) + " feishu:msg:old\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := openSeenStore(path, seenTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.seen) != 0 {
+		t.Fatalf("loaded %d entries, want malformed and expired lines dropped", len(s.seen))
+	}
+
+	for i := 0; i < seenCompactLines-1; i++ {
+		s.add("feishu:msg:" + strconv.Itoa(i))
+	}
+	// Let everything written so far expire
+	for key := range s.seen {
+		s.seen[key] = time.Unix(expired, 0)
+	}
+	s.add("feishu:msg:last")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(data)); !strings.HasSuffix(got, " feishu:msg:last") || strings.Count(got, "\n") != 0 {
+		t.Fatalf("log after compaction = %q, want only the live entry", got)
+	}
+
+	// Appends continue after compaction
+	s.add("feishu:msg:next")
+	if s, err = openSeenStore(path, seenTTL); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.seen) != 2 {
+		t.Fatalf("reloaded %d entries, want 2", len(s.seen))
+	}
+}
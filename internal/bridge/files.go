@@ -0,0 +1,197 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+const (
+	defaultMaxFileBytes = 50 << 20
+	// fileLinkWindow is how long a staged file waits for the message that
+	// refers to it
+	fileLinkWindow = 10 * time.Minute
+	// fileRetention is how long staged files are kept on disk
+	fileRetention       = 24 * time.Hour
+	fileDownloadTimeout = 2 * time.Minute
+)
+
+// stagedFile is a file message that is downloaded to disk once a message
+// refers to it
+type stagedFile struct {
+	name     string
+	path     string
+	size     int64
+	err      error
+	received time.Time
+	done     chan struct{}
+
+	downloader im.Downloader
+	messageID  string
+	res        im.Resource
+}
+
+// fileStager holds file messages until a later message in the same chat asks
+// about them, and only then downloads them
+type fileStager struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	pending map[string][]*stagedFile
+}
+
+func newFileStager(dir string, maxBytes int64) *fileStager {
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxFileBytes
+	}
+	return &fileStager{
+		dir:      dir,
+		maxBytes: maxBytes,
+		pending:  make(map[string][]*stagedFile),
+	}
+}
+
+// stage links the file resources of msg to chat without downloading them.
+// It returns the names of the files being staged.
+func (s *fileStager) stage(downloader im.Downloader, chat string, msg *im.Message) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneLocked(time.Now())
+
+	var names []string
+	for _, res := range msg.Resources {
+		if res.Type != im.ResourceFile {
+			continue
+		}
+
+		f := &stagedFile{
+			name:       safeFileName(res.Name),
+			received:   time.Now(),
+			done:       make(chan struct{}),
+			downloader: downloader,
+			messageID:  msg.MessageID,
+			res:        res,
+		}
+		f.path = filepath.Join(s.dir, safeFileName(msg.MessageID), f.name)
+
+		s.pending[chat] = append(s.pending[chat], f)
+		names = append(names, f.name)
+	}
+	return names
+}
+
+// pruneLocked drops files that were never asked about within the link
+// window. The caller must hold s.mu.
+func (s *fileStager) pruneLocked(now time.Time) {
+	for chat, files := range s.pending {
+		kept := files[:0]
+		for _, f := range files {
+			if now.Sub(f.received) <= fileLinkWindow {
+				kept = append(kept, f)
+			}
+		}
+		if len(kept) == 0 {
+			delete(s.pending, chat)
+		} else {
+			s.pending[chat] = kept
+		}
+	}
+}
+
+func (s *fileStager) download(f *stagedFile) {
+	defer close(f.done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), fileDownloadTimeout)
+	defer cancel()
+
+	data, err := f.downloader.DownloadResource(ctx, f.messageID, f.res, s.maxBytes)
+	if errors.Is(err, im.ErrTooLarge) {
+		f.err = fmt.Errorf("文件大小超过 %s 的限制", formatBytes(s.maxBytes))
+		return
+	}
+	if err != nil {
+		f.err = fmt.Errorf("下载失败: %w", err)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f.path), 0700); err != nil {
+		f.err = err
+		return
+	}
+	if err := os.WriteFile(f.path, data, 0600); err != nil {
+		f.err = err
+		return
+	}
+	f.size = int64(len(data))
+	log.Printf("[Bridge] Staged file %s (%d bytes)", f.path, f.size)
+}
+
+// take removes the files waiting in chat that were received within the link
+// window and starts downloading them
+func (s *fileStager) take(chat string) []*stagedFile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var files []*stagedFile
+	for _, f := range s.pending[chat] {
+		if time.Since(f.received) <= fileLinkWindow {
+			files = append(files, f)
+			go s.download(f)
+		}
+	}
+	delete(s.pending, chat)
+
+	if len(files) > 0 {
+		go s.removeExpired()
+	}
+	return files
+}
+
+// removeExpired deletes staged files that are past the retention period
+func (s *fileStager) removeExpired() {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < fileRetention {
+			continue
+		}
+		os.RemoveAll(filepath.Join(s.dir, entry.Name()))
+	}
+}
+
+// fileReferences waits for the downloads of files and describes them for
+// the agent prompt
+func fileReferences(files []*stagedFile) string {
+	var b strings.Builder
+	for _, f := range files {
+		<-f.done
+		if f.err != nil {
+			fmt.Fprintf(&b, "\n[附件] %s（未能获取：%v）", f.name, f.err)
+			continue
+		}
+		fmt.Fprintf(&b, "\n[附件] %s（%s）已保存到本地：%s", f.name, formatBytes(f.size), f.path)
+	}
+	return b.String()
+}
+
+// safeFileName reduces a user-supplied name to a single path element
+func safeFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" || name == ".." || name == "" {
+		return "file"
+	}
+	return name
+}
@@ -0,0 +1,73 @@
+package bridge
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+func TestFileStagerLinksFilesToNextMessage(t *testing.T) {
+	dir := t.TempDir()
+	stager := newFileStager(dir, 16)
+	m := &fakeDownloader{resources: map[string][]byte{
+		"file-log":   []byte("panic: boom\n"),
+		"file-large": []byte(strings.Repeat("x", 32)),
+	}}
+
+	names := stager.stage(m, "feishu:oc_1", &im.Message{MessageID: "om_1", Resources: []im.Resource{
+		{Type: im.ResourceFile, Key: "file-log", Name: "../../app.log"},
+		{Type: im.ResourceFile, Key: "file-large", Name: "dump.bin"},
+	}})
+	if len(names) != 2 || names[0] != "app.log" {
+		t.Fatalf("stage() = %v, want sanitized names", names)
+	}
+	if n := m.downloads.Load(); n != 0 {
+		t.Fatalf("stage() downloaded %d files, want none until they are linked", n)
+	}
+
+	if files := stager.take("feishu:oc_2"); len(files) != 0 {
+		t.Fatalf("take() in another chat = %d files, want 0", len(files))
+	}
+	files := stager.take("feishu:oc_1")
+	if len(files) != 2 {
+		t.Fatalf("take() = %d files, want 2", len(files))
+	}
+
+	refs := fileReferences(files)
+	wantPath := filepath.Join(dir, "om_1", "app.log")
+	if !strings.Contains(refs, wantPath) || !strings.Contains(refs, "dump.bin（未能获取：文件大小") {
+		t.Fatalf("fileReferences() = %q", refs)
+	}
+	if data, err := os.ReadFile(wantPath); err != nil || string(data) != "panic: boom\n" {
+		t.Fatalf("staged file = %q, %v", data, err)
+	}
+
+	if files := stager.take("feishu:oc_1"); len(files) != 0 {
+		t.Fatalf("take() after take = %d files, want 0", len(files))
+	}
+}
+
+func TestFileStagerPrunesUnlinkedFiles(t *testing.T) {
+	stager := newFileStager(t.TempDir(), 16)
+	m := &fakeDownloader{resources: map[string][]byte{"file-1": []byte("data")}}
+	file := []im.Resource{{Type: im.ResourceFile, Key: "file-1", Name: "a.txt"}}
+
+	stager.stage(m, "feishu:oc_1", &im.Message{MessageID: "om_1", Resources: file})
+	stager.pending["feishu:oc_1"][0].received = time.Now().Add(-fileLinkWindow - time.Minute)
+
+	// Staging in any chat drops files nobody asked about in time
+	stager.stage(m, "feishu:oc_2", &im.Message{MessageID: "om_2", Resources: file})
+	if _, ok := stager.pending["feishu:oc_1"]; ok {
+		t.Fatal("expired files of oc_1 are still pending")
+	}
+	if files := stager.pending["feishu:oc_2"]; len(files) != 1 {
+		t.Fatalf("pending in oc_2 = %d files, want 1", len(files))
+	}
+	if n := m.downloads.Load(); n != 0 {
+		t.Fatalf("pruned files were downloaded %d times, want 0", n)
+	}
+}
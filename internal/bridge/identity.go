@@ -0,0 +1,146 @@
+package bridge
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+const (
+	// nameTTL is how long resolved display names are cached
+	nameTTL = time.Hour
+	// nameFailureTTL is shorter so a missing permission, once granted,
+	// takes effect without a restart
+	nameFailureTTL    = 5 * time.Minute
+	nameLookupTimeout = 5 * time.Second
+)
+
+// nameEntry is a cached display name; an empty name records a failed lookup
+type nameEntry struct {
+	name    string
+	expires time.Time
+}
+
+// nameCache caches display names looked up through a Directory
+type nameCache struct {
+	mu      sync.Mutex
+	entries map[string]nameEntry
+}
+
+func newNameCache() *nameCache {
+	return &nameCache{entries: make(map[string]nameEntry)}
+}
+
+// resolve returns the cached name for key or calls lookup. It returns ""
+// when the name is unknown.
+func (c *nameCache) resolve(key string, lookup func() (string, error)) string {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.name
+	}
+
+	name, err := lookup()
+	ttl := nameTTL
+	if err != nil || name == "" {
+		if err != nil {
+			log.Printf("[Bridge] Failed to look up name of %s: %v", key, err)
+		}
+		name = ""
+		ttl = nameFailureTTL
+	}
+
+	c.mu.Lock()
+	c.entries[key] = nameEntry{name: name, expires: time.Now().Add(ttl)}
+	// Drop expired entries while we hold the lock anyway
+	if len(c.entries) > 1000 {
+		now := time.Now()
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	return name
+}
+
+// senderName returns the display name of the sender of msg, falling back to
+// the sender ID
+func (b *Bridge) senderName(ctx context.Context, m im.Messenger, msg *im.Message) string {
+	if msg.Sender.Name != "" {
+		return msg.Sender.Name
+	}
+	if msg.Sender.ID == "" {
+		return ""
+	}
+	if dir, ok := m.(im.Directory); ok {
+		name := b.names.resolve(msg.Platform+":user:"+msg.Sender.ID, func() (string, error) {
+			return dir.UserName(ctx, msg.Sender.ID)
+		})
+		if name != "" {
+			return name
+		}
+	}
+	return msg.Sender.ID
+}
+
+// chatName returns the name of the chat of msg, falling back to the chat ID
+func (b *Bridge) chatName(ctx context.Context, m im.Messenger, msg *im.Message) string {
+	if msg.ChatName != "" {
+		return msg.ChatName
+	}
+	if dir, ok := m.(im.Directory); ok && msg.ChatType == im.ChatTypeGroup {
+		name := b.names.resolve(chatKey(msg), func() (string, error) {
+			return dir.ChatName(ctx, msg.ChatID)
+		})
+		if name != "" {
+			return name
+		}
+	}
+	return msg.ChatID
+}
+
+// messageHeader renders the metadata line put in front of the text sent to
+// the agent, e.g. "[张三 in #ops-group]". It returns "" when the template
+// for the chat type is empty.
+func (b *Bridge) messageHeader(m im.Messenger, msg *im.Message) string {
+	template := b.headers.p2p
+	if msg.ChatType == im.ChatTypeGroup {
+		template = b.headers.group
+	}
+	if template == "" {
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), nameLookupTimeout)
+	defer cancel()
+
+	fields := map[string]func() string{
+		"platform":  func() string { return msg.Platform },
+		"sender":    func() string { return b.senderName(ctx, m, msg) },
+		"sender_id": func() string { return msg.Sender.ID },
+		"chat":      func() string { return b.chatName(ctx, m, msg) },
+		"chat_id":   func() string { return msg.ChatID },
+	}
+
+	// Names are only looked up when the template uses them
+	return templateFieldPattern.ReplaceAllStringFunc(template, func(field string) string {
+		if value, ok := fields[strings.Trim(field, "{}")]; ok {
+			return value()
+		}
+		return field
+	})
+}
+
+// headerTemplates holds the message header template per chat type
+type headerTemplates struct {
+	group string
+	p2p   string
+}
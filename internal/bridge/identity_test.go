@@ -0,0 +1,64 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+// fakeDirectory is a messenger that resolves names from memory
+type fakeDirectory struct {
+	fakeReplier
+	users   map[string]string
+	lookups int
+}
+
+func (f *fakeDirectory) UserName(ctx context.Context, userID string) (string, error) {
+	f.lookups++
+	if name, ok := f.users[userID]; ok {
+		return name, nil
+	}
+	return "", errors.New("no permission")
+}
+
+func (f *fakeDirectory) ChatName(ctx context.Context, chatID string) (string, error) {
+	f.lookups++
+	return "ops-group", nil
+}
+
+func TestMessageHeader(t *testing.T) {
+	b, err := NewBridge(nil, Options{GroupHeader: "[{sender} in #{chat}]", P2PHeader: "[{sender}]"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &fakeDirectory{users: map[string]string{"ou_a": "张三"}}
+
+	group := &im.Message{Platform: "fake", ChatID: "oc_1", ChatType: im.ChatTypeGroup, Sender: im.Sender{ID: "ou_a"}}
+	if got := b.messageHeader(m, group); got != "[张三 in #ops-group]" {
+		t.Fatalf("group header = %q", got)
+	}
+	if got := b.messageHeader(m, group); got != "[张三 in #ops-group]" || m.lookups != 2 {
+		t.Fatalf("cached group header = %q after %d lookups, want 2", got, m.lookups)
+	}
+
+	// Unknown users fall back to their ID
+	p2p := &im.Message{Platform: "fake", ChatID: "oc_2", ChatType: im.ChatTypeP2P, Sender: im.Sender{ID: "ou_b"}}
+	if got := b.messageHeader(m, p2p); got != "[ou_b]" {
+		t.Fatalf("p2p header = %q", got)
+	}
+
+	// Names carried by the event need no lookup
+	named := &im.Message{Platform: "fake", ChatID: "cid", ChatType: im.ChatTypeGroup, ChatName: "值班群",
+		Sender: im.Sender{ID: "staff-1", Name: "李四"}}
+	lookups := m.lookups
+	if got := b.messageHeader(m, named); got != "[李四 in #值班群]" || m.lookups != lookups {
+		t.Fatalf("header from event names = %q", got)
+	}
+
+	b.headers.group = ""
+	if got := b.messageHeader(m, group); got != "" {
+		t.Fatalf("disabled header = %q", got)
+	}
+}
@@ -0,0 +1,93 @@
+package bridge
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+	"github.com/wy51ai/moltbotCNAPP/internal/ratelimit"
+)
+
+// limitNoticeInterval keeps a user who keeps sending from getting a
+// "limited" reply to every message
+const limitNoticeInterval = time.Minute
+
+// limitNotices remembers when each key was last told it is limited
+type limitNotices struct {
+	mu   sync.Mutex
+	sent map[string]time.Time
+}
+
+// due reports whether key should be told again, and records it
+func (n *limitNotices) due(key string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := time.Now()
+	for k, sent := range n.sent {
+		if now.Sub(sent) > limitNoticeInterval {
+			delete(n.sent, k)
+		}
+	}
+	if _, ok := n.sent[key]; ok {
+		return false
+	}
+	n.sent[key] = now
+	return true
+}
+
+// senderLimitKey identifies a sender for rate limits
+func senderLimitKey(platform, senderID string) string {
+	return fmt.Sprintf("%s:user:%s", platform, senderID)
+}
+
+// admit takes one agent request from the sender's and the chat's limits.
+// Senders without an ID only count against the chat, so they do not share
+// one quota. Limited users get a reply explaining when they can ask again.
+func (b *Bridge) admit(m im.Messenger, msg *im.Message) bool {
+	reqs := []ratelimit.Request{{Key: chatKey(msg), Limit: b.chatLimit}}
+	senderKey := ""
+	if msg.Sender.ID != "" {
+		senderKey = senderLimitKey(msg.Platform, msg.Sender.ID)
+		reqs = append([]ratelimit.Request{{Key: senderKey, Limit: b.senderLimit}}, reqs...)
+	}
+	res := b.limiter.Allow(reqs...)
+	if res.Allowed {
+		return true
+	}
+
+	log.Printf("[Bridge] Rate limited %s in %s (key %s)", msg.Sender.ID, msg.ChatID, res.Key)
+	if b.limitNotices.due(res.Key) {
+		go b.reply(m, msg, limitedReply(res, res.Key == senderKey))
+	}
+	return false
+}
+
+// limitedReply explains a refused request
+func limitedReply(res ratelimit.Result, sender bool) string {
+	if res.Daily == 0 {
+		return fmt.Sprintf("消息有点多，请 %s后再试。", formatWait(res.RetryAfter))
+	}
+	if sender {
+		return fmt.Sprintf("你今天的 %d 次使用额度已用完，明天再来吧。", res.Daily)
+	}
+	return fmt.Sprintf("本聊天今天的 %d 次使用额度已用完，明天再来吧。", res.Daily)
+}
+
+// formatWait rounds a wait up to whole seconds or minutes
+func formatWait(d time.Duration) string {
+	if d <= time.Minute {
+		return fmt.Sprintf("%d 秒", int((d+time.Second-1)/time.Second))
+	}
+	return fmt.Sprintf("%d 分钟", int((d+time.Minute-1)/time.Minute))
+}
+
+// formatQuota describes how much of a daily quota was used
+func formatQuota(used, daily int) string {
+	if daily == 0 {
+		return fmt.Sprintf("%d 次（不限）", used)
+	}
+	return fmt.Sprintf("%d/%d 次", used, daily)
+}
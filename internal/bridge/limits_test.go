@@ -0,0 +1,79 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+	"github.com/wy51ai/moltbotCNAPP/internal/ratelimit"
+)
+
+func TestAdmitAndQuotaCommand(t *testing.T) {
+	b, err := NewBridge(nil, Options{
+		SenderLimit: ratelimit.Limit{Daily: 1},
+		Admins:      []string{"ou_admin"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &fakeReplier{}
+	msg := &im.Message{Platform: "fake", ChatID: "oc_1", ChatType: im.ChatTypeGroup, Sender: im.Sender{ID: "ou_a"}}
+	key := chatKey(msg)
+
+	if !b.admit(m, msg) {
+		t.Fatal("first request refused")
+	}
+	if b.admit(m, msg) {
+		t.Fatal("second request admitted over the daily quota")
+	}
+
+	reply, _ := quotaCommand(b, &CommandRequest{ChatKey: key, Message: msg})
+	if !strings.Contains(reply, "1/1 次") || !strings.Contains(reply, "本聊天今天已使用：1 次（不限）") {
+		t.Fatalf("quota reply = %q", reply)
+	}
+
+	if reply, _ := quotaCommand(b, &CommandRequest{ChatKey: key, Args: "reset ou_a", Message: msg}); !strings.Contains(reply, "管理员") {
+		t.Fatalf("member reset reply = %q, want a refusal", reply)
+	}
+	admin := &im.Message{Platform: "fake", ChatID: "oc_1", Sender: im.Sender{ID: "ou_admin"}}
+	if _, err := quotaCommand(b, &CommandRequest{ChatKey: key, Args: "reset ou_a", Message: admin}); err != nil {
+		t.Fatal(err)
+	}
+	if !b.admit(m, msg) {
+		t.Fatal("request refused after the admin reset the quota")
+	}
+}
+
+func TestAdmitWithoutSenderID(t *testing.T) {
+	b, err := NewBridge(nil, Options{SenderLimit: ratelimit.Limit{Daily: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &fakeReplier{}
+
+	// Senders the platform cannot identify do not share one quota
+	for i := 0; i < 3; i++ {
+		msg := &im.Message{Platform: "fake", ChatID: "oc_1", ChatType: im.ChatTypeGroup}
+		if !b.admit(m, msg) {
+			t.Fatalf("request %d without a sender ID refused", i+1)
+		}
+	}
+}
+
+func TestLimitedReply(t *testing.T) {
+	for _, tc := range []struct {
+		res    ratelimit.Result
+		sender bool
+		want   string
+	}{
+		{ratelimit.Result{RetryAfter: 1500 * time.Millisecond}, true, "消息有点多，请 2 秒后再试。"},
+		{ratelimit.Result{RetryAfter: 90 * time.Second}, false, "消息有点多，请 2 分钟后再试。"},
+		{ratelimit.Result{Daily: 50}, true, "你今天的 50 次使用额度已用完，明天再来吧。"},
+		{ratelimit.Result{Daily: 200}, false, "本聊天今天的 200 次使用额度已用完，明天再来吧。"},
+	} {
+		if got := limitedReply(tc.res, tc.sender); got != tc.want {
+			t.Fatalf("limitedReply(%+v) = %q, want %q", tc.res, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package bridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/clawdbot"
+)
+
+// Progress modes decide how much of the agent's tool use is shown while
+// it works
+const (
+	// ProgressOff shows only the reply
+	ProgressOff = "off"
+	// ProgressSummary lists the latest tool calls on one line each
+	ProgressSummary = "summary"
+	// ProgressDetailed lists more calls with longer arguments and results
+	ProgressDetailed = "detailed"
+)
+
+// ValidProgressMode reports whether mode is a known progress mode
+func ValidProgressMode(mode string) bool {
+	switch mode {
+	case ProgressOff, ProgressSummary, ProgressDetailed:
+		return true
+	}
+	return false
+}
+
+// progressLimits bound the tool list so it stays a small part of the message
+var progressLimits = map[string]struct {
+	calls, args, result int
+}{
+	ProgressSummary:  {calls: 5, args: 40},
+	ProgressDetailed: {calls: 15, args: 120, result: 100},
+}
+
+// toolCall is one tool invocation seen in the agent's event stream
+type toolCall struct {
+	id       string
+	name     string
+	args     json.RawMessage
+	result   json.RawMessage
+	started  time.Time
+	finished time.Time
+	failed   bool
+}
+
+// toolProgress collects the tool calls of one run. It is not safe for
+// concurrent use.
+type toolProgress struct {
+	calls []*toolCall
+}
+
+// handle records a tool_call or tool_result event
+func (p *toolProgress) handle(stream, data string, now time.Time) {
+	var tool clawdbot.ToolData
+	if err := json.Unmarshal([]byte(data), &tool); err != nil {
+		return
+	}
+
+	switch stream {
+	case "tool_call":
+		p.calls = append(p.calls, &toolCall{
+			id:      tool.ToolCallID,
+			name:    tool.Name,
+			args:    tool.Args,
+			started: now,
+		})
+	case "tool_result":
+		if call := p.pending(tool); call != nil {
+			call.result = tool.Result
+			call.failed = tool.IsError
+			call.finished = now
+		}
+	}
+}
+
+// pending finds the running call a result belongs to, by ID or else the
+// oldest running call of the same tool
+func (p *toolProgress) pending(result clawdbot.ToolData) *toolCall {
+	for _, call := range p.calls {
+		if !call.finished.IsZero() {
+			continue
+		}
+		if result.ToolCallID != "" && call.id == result.ToolCallID {
+			return call
+		}
+		if result.ToolCallID == "" && (result.Name == "" || call.name == result.Name) {
+			return call
+		}
+	}
+	return nil
+}
+
+// render lists the tool calls for mode, empty when there is nothing to show
+func (p *toolProgress) render(mode string) string {
+	limits, ok := progressLimits[mode]
+	if !ok || len(p.calls) == 0 {
+		return ""
+	}
+
+	calls := p.calls
+	var sb strings.Builder
+	if hidden := len(calls) - limits.calls; hidden > 0 {
+		fmt.Fprintf(&sb, "…已省略 %d 次工具调用\n", hidden)
+		calls = calls[hidden:]
+	}
+	for _, call := range calls {
+		status := "⏳"
+		switch {
+		case call.finished.IsZero():
+		case call.failed:
+			status = "❌"
+		default:
+			status = "✅"
+		}
+		fmt.Fprintf(&sb, "%s `%s`", status, call.name)
+		if args := summarizeJSON(call.args, limits.args); args != "" {
+			fmt.Fprintf(&sb, "（%s）", args)
+		}
+		if call.finished.IsZero() {
+			sb.WriteString(" 运行中")
+		} else {
+			sb.WriteString(" " + formatDuration(call.finished.Sub(call.started)))
+		}
+		if result := summarizeJSON(call.result, limits.result); result != "" {
+			sb.WriteString("\n　→ " + result)
+		}
+		sb.WriteString("\n")
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// summarizeJSON renders a JSON value on one line of at most maxRunes,
+// showing objects as "key=value" pairs. maxRunes 0 shows nothing.
+func summarizeJSON(raw json.RawMessage, maxRunes int) string {
+	if maxRunes <= 0 || len(raw) == 0 {
+		return ""
+	}
+
+	var text string
+	var fields map[string]json.RawMessage
+	var str string
+	switch {
+	case json.Unmarshal(raw, &str) == nil:
+		text = str
+	case json.Unmarshal(raw, &fields) == nil:
+		keys := make([]string, 0, len(fields))
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		pairs := make([]string, len(keys))
+		for i, key := range keys {
+			value := string(fields[key])
+			if json.Unmarshal(fields[key], &str) == nil {
+				value = str
+			}
+			pairs[i] = key + "=" + value
+		}
+		text = strings.Join(pairs, ", ")
+	default:
+		var compact bytes.Buffer
+		if json.Compact(&compact, raw) == nil {
+			text = compact.String()
+		} else {
+			text = string(raw)
+		}
+	}
+
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > maxRunes {
+		text = string(runes[:maxRunes]) + "…"
+	}
+	return text
+}
+
+// formatDuration shows how long a tool ran
+func formatDuration(d time.Duration) string {
+	switch {
+	case d < 10*time.Second:
+		return fmt.Sprintf("%.1f 秒", d.Seconds())
+	case d < time.Minute:
+		return fmt.Sprintf("%d 秒", int(d/time.Second))
+	default:
+		return fmt.Sprintf("%d 分 %d 秒", int(d/time.Minute), int(d%time.Minute/time.Second))
+	}
+}
+
+// progressModeFor returns the progress mode of a chat
+func (b *Bridge) progressModeFor(chatKey string) string {
+	if mode := b.settings.get(chatKey).Progress; mode != "" {
+		return mode
+	}
+	return b.progressMode
+}
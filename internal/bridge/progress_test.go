@@ -0,0 +1,91 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+func TestToolProgress(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	var p toolProgress
+	p.handle("tool_call", `{"toolCallId":"c1","name":"read_file","args":{"path":"/var/log/app.log","lines":200}}`, start)
+	p.handle("tool_call", `{"toolCallId":"c2","name":"exec","args":{"command":"kubectl get pods -n prod"}}`, start.Add(time.Second))
+	p.handle("tool_result", `{"toolCallId":"c1","name":"read_file","result":"ERROR connection refused\nat main.go:12"}`, start.Add(1500*time.Millisecond))
+
+	summary := p.render(ProgressSummary)
+	want := "✅ `read_file`（lines=200, path=/var/log/app.log） 1.5 秒\n" +
+		"⏳ `exec`（command=kubectl get pods -n prod） 运行中"
+	if summary != want {
+		t.Fatalf("summary =\n%s\nwant\n%s", summary, want)
+	}
+
+	p.handle("tool_result", `{"toolCallId":"c2","isError":true,"result":{"error":"forbidden"}}`, start.Add(75*time.Second))
+	detailed := p.render(ProgressDetailed)
+	for _, line := range []string{
+		"　→ ERROR connection refused at main.go:12",
+		"❌ `exec`（command=kubectl get pods -n prod） 1 分 14 秒",
+		"　→ error=forbidden",
+	} {
+		if !strings.Contains(detailed, line) {
+			t.Fatalf("detailed =\n%s\nwant a line %q", detailed, line)
+		}
+	}
+
+	if got := p.render(ProgressOff); got != "" {
+		t.Fatalf("off = %q, want nothing", got)
+	}
+}
+
+func TestToolProgressHidesOldCalls(t *testing.T) {
+	var p toolProgress
+	for i := 0; i < 8; i++ {
+		p.handle("tool_call", `{"name":"search"}`, time.Now())
+		p.handle("tool_result", `{"name":"search"}`, time.Now())
+	}
+
+	lines := strings.Split(p.render(ProgressSummary), "\n")
+	if len(lines) != 6 || lines[0] != "…已省略 3 次工具调用" {
+		t.Fatalf("summary = %q, want 3 hidden calls and the last 5", lines)
+	}
+}
+
+func TestSummarizeJSONTruncates(t *testing.T) {
+	if got := summarizeJSON([]byte(`"一二三四五六"`), 4); got != "一二三四…" {
+		t.Fatalf("summarizeJSON() = %q, want 一二三四…", got)
+	}
+	if got := summarizeJSON([]byte(`[1, 2]`), 10); got != "[1,2]" {
+		t.Fatalf("summarizeJSON() = %q, want [1,2]", got)
+	}
+}
+
+func TestProgressCommand(t *testing.T) {
+	b, err := NewBridge(nil, Options{Admins: []string{"ou_admin"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	member := &CommandRequest{ChatKey: "fake:oc_1", Args: "off", Message: &im.Message{Sender: im.Sender{ID: "ou_member"}}}
+	if reply, _ := progressCommand(b, member); !strings.Contains(reply, "admins") || !strings.Contains(reply, "ou_member") {
+		t.Fatalf("member reply = %q, want a refusal naming the admins setting and the sender ID", reply)
+	}
+
+	req := &CommandRequest{ChatKey: "fake:oc_1", Message: &im.Message{Sender: im.Sender{ID: "ou_admin"}}}
+	for _, tt := range []struct{ args, reply string }{
+		{"", "当前工具调用进度：简要"},
+		{"detailed", "工具调用进度已设置为：详细"},
+		{"loud", "用法：/progress [off|summary|detailed|default]"},
+		{"default", "工具调用进度已设置为：简要"},
+	} {
+		req.Args = tt.args
+		reply, err := progressCommand(b, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply != tt.reply {
+			t.Fatalf("/progress %s = %q, want %q", tt.args, reply, tt.reply)
+		}
+	}
+}
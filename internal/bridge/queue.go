@@ -0,0 +1,105 @@
+package bridge
+
+import "sync"
+
+// runQueue runs jobs one at a time per session key, in arrival order, while
+// different sessions proceed in parallel up to a global limit
+type runQueue struct {
+	sem chan struct{} // nil means no global limit
+
+	mu       sync.Mutex
+	sessions map[string]*sessionQueue
+	queued   int
+	running  int
+	// idle is signalled when the last job finishes
+	idle *sync.Cond
+}
+
+// sessionQueue holds the jobs waiting behind the current run of one session
+type sessionQueue struct {
+	jobs []func()
+}
+
+func newRunQueue(maxConcurrent int) *runQueue {
+	q := &runQueue{
+		sessions: make(map[string]*sessionQueue),
+	}
+	q.idle = sync.NewCond(&q.mu)
+	if maxConcurrent > 0 {
+		q.sem = make(chan struct{}, maxConcurrent)
+	}
+	return q
+}
+
+// enqueue schedules job after every earlier job of the same session
+func (q *runQueue) enqueue(sessionKey string, job func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.queued++
+
+	sq, active := q.sessions[sessionKey]
+	if !active {
+		sq = &sessionQueue{}
+		q.sessions[sessionKey] = sq
+	}
+	sq.jobs = append(sq.jobs, job)
+
+	if !active {
+		go q.drain(sessionKey, sq)
+	}
+}
+
+// drain runs the jobs of one session until its queue is empty
+func (q *runQueue) drain(sessionKey string, sq *sessionQueue) {
+	for {
+		q.mu.Lock()
+		if len(sq.jobs) == 0 {
+			delete(q.sessions, sessionKey)
+			q.mu.Unlock()
+			return
+		}
+		job := sq.jobs[0]
+		sq.jobs = sq.jobs[1:]
+		q.mu.Unlock()
+
+		if q.sem != nil {
+			q.sem <- struct{}{}
+		}
+
+		q.mu.Lock()
+		q.queued--
+		q.running++
+		q.mu.Unlock()
+
+		job()
+
+		q.mu.Lock()
+		q.running--
+		if q.running == 0 && q.queued == 0 {
+			q.idle.Broadcast()
+		}
+		q.mu.Unlock()
+
+		if q.sem != nil {
+			<-q.sem
+		}
+	}
+}
+
+// stats returns the number of running jobs and jobs still waiting
+func (q *runQueue) stats() (running, queued int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.running, q.queued
+}
+
+// wait blocks until no job is running or waiting
+func (q *runQueue) wait() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.running > 0 || q.queued > 0 {
+		q.idle.Wait()
+	}
+}
@@ -0,0 +1,90 @@
+package bridge
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunQueueKeepsSessionOrder(t *testing.T) {
+	q := newRunQueue(0)
+
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		i := i
+		q.enqueue("session", func() {
+			defer wg.Done()
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+
+	for i, got := range order {
+		if got != i {
+			t.Fatalf("job order = %v, want ascending", order)
+		}
+	}
+}
+
+func TestRunQueueRunsSessionsInParallel(t *testing.T) {
+	q := newRunQueue(0)
+
+	release := make(chan struct{})
+	started := make(chan string, 2)
+
+	for _, key := range []string{"a", "b"} {
+		key := key
+		q.enqueue(key, func() {
+			started <- key
+			<-release
+		})
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Fatal("sessions did not start in parallel")
+		}
+	}
+	close(release)
+}
+
+func TestRunQueueRespectsGlobalLimit(t *testing.T) {
+	q := newRunQueue(1)
+
+	release := make(chan struct{})
+	started := make(chan string, 2)
+
+	for _, key := range []string{"a", "b"} {
+		key := key
+		q.enqueue(key, func() {
+			started <- key
+			<-release
+		})
+	}
+
+	<-started
+	select {
+	case key := <-started:
+		t.Fatalf("session %s started while the limit was reached", key)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if running, queued := q.stats(); running != 1 || queued != 1 {
+		t.Fatalf("stats() = (%d, %d), want (1, 1)", running, queued)
+	}
+
+	close(release)
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("second session never started")
+	}
+}
@@ -0,0 +1,57 @@
+package bridge
+
+import (
+	"context"
+	"log"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+// Reply modes decide where answers are posted
+const (
+	// ReplyModeMessage posts answers as new messages in the chat
+	ReplyModeMessage = "message"
+	// ReplyModeQuote answers with a reply that quotes the question
+	ReplyModeQuote = "reply"
+	// ReplyModeThread answers in a thread under the question
+	ReplyModeThread = "thread"
+)
+
+// ValidReplyMode reports whether mode is one of the reply modes
+func ValidReplyMode(mode string) bool {
+	switch mode {
+	case ReplyModeMessage, ReplyModeQuote, ReplyModeThread:
+		return true
+	}
+	return false
+}
+
+// replyModeFor returns the reply mode of a chat
+func (b *Bridge) replyModeFor(chatKey string) string {
+	if mode := b.settings.get(chatKey).ReplyMode; mode != "" {
+		return mode
+	}
+	return b.replyMode
+}
+
+// sendReply answers msg according to the chat's reply mode. Platforms that
+// cannot reply to a message, or a failed reply, fall back to a new message.
+func (b *Bridge) sendReply(ctx context.Context, m im.Messenger, msg *im.Message, text string) (string, error) {
+	mode := b.replyModeFor(chatKey(msg))
+	if replier, ok := m.(im.Replier); ok && mode != ReplyModeMessage && msg.MessageID != "" {
+		messageID, err := replier.ReplyMessage(ctx, msg.MessageID, text, mode == ReplyModeThread)
+		if err == nil {
+			return messageID, nil
+		}
+		// The question may have been recalled in the meantime
+		log.Printf("[Bridge] Failed to reply to %s, sending new message: %v", msg.MessageID, err)
+	}
+	return m.SendMessage(ctx, msg.ChatID, text)
+}
+
+// reply sends a short notice in response to msg
+func (b *Bridge) reply(m im.Messenger, msg *im.Message, text string) {
+	if _, err := b.sendReply(context.Background(), m, msg, text); err != nil {
+		log.Printf("[Bridge] Failed to send message: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+// fakeReplier records how messages were posted
+type fakeReplier struct {
+	calls     []string
+	failReply bool
+}
+
+func (f *fakeReplier) Platform() string { return "fake" }
+
+func (f *fakeReplier) Start(ctx context.Context, handler im.Handler) error { return nil }
+
+func (f *fakeReplier) SendMessage(ctx context.Context, chatID, text string) (string, error) {
+	f.calls = append(f.calls, "send:"+chatID)
+	return "om_new", nil
+}
+
+func (f *fakeReplier) ReplyMessage(ctx context.Context, messageID, text string, inThread bool) (string, error) {
+	if f.failReply {
+		return "", errors.New("message recalled")
+	}
+	if inThread {
+		f.calls = append(f.calls, "thread:"+messageID)
+	} else {
+		f.calls = append(f.calls, "reply:"+messageID)
+	}
+	return "om_reply", nil
+}
+
+func TestSendReplyFollowsChatReplyMode(t *testing.T) {
+	b, err := NewBridge(nil, Options{Admins: []string{"ou_admin"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &im.Message{Platform: "fake", MessageID: "om_q", ChatID: "oc_1", ChatType: im.ChatTypeGroup,
+		Sender: im.Sender{ID: "ou_admin"}}
+	key := chatKey(msg)
+
+	for _, tc := range []struct {
+		mode string
+		want string
+	}{
+		{"", "reply:om_q"},
+		{ReplyModeThread, "thread:om_q"},
+		{ReplyModeMessage, "send:oc_1"},
+	} {
+		if _, err := replyCommand(b, &CommandRequest{ChatKey: key, Args: tc.mode, Message: msg}); err != nil {
+			t.Fatal(err)
+		}
+
+		m := &fakeReplier{}
+		if _, err := b.sendReply(context.Background(), m, msg, "hi"); err != nil {
+			t.Fatalf("sendReply() error: %v", err)
+		}
+		if len(m.calls) != 1 || m.calls[0] != tc.want {
+			t.Fatalf("mode %q posted %v, want %s", tc.mode, m.calls, tc.want)
+		}
+	}
+
+	// A failed reply falls back to a new message
+	replyCommand(b, &CommandRequest{ChatKey: key, Args: "default", Message: msg})
+	m := &fakeReplier{failReply: true}
+	if id, err := b.sendReply(context.Background(), m, msg, "hi"); err != nil || id != "om_new" {
+		t.Fatalf("sendReply() after failed reply = %q, %v", id, err)
+	}
+}
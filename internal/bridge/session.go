@@ -0,0 +1,116 @@
+package bridge
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+// Session strategies decide which messages share an agent context
+const (
+	// SessionPerChat shares one session among everyone in a chat
+	SessionPerChat = "chat"
+	// SessionPerSender gives each sender their own session within a chat
+	SessionPerSender = "sender"
+	// SessionPerThread gives each thread its own session
+	SessionPerThread = "thread"
+)
+
+var templateFieldPattern = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// sessionTemplateFields are the placeholders a custom strategy may use
+var sessionTemplateFields = map[string]func(msg *im.Message) string{
+	"platform":  func(msg *im.Message) string { return msg.Platform },
+	"chat_id":   func(msg *im.Message) string { return msg.ChatID },
+	"chat_type": func(msg *im.Message) string { return msg.ChatType },
+	"sender_id": func(msg *im.Message) string { return msg.Sender.ID },
+	"thread_id": threadRoot,
+}
+
+// requiredTemplateFields must appear in every custom strategy
+var requiredTemplateFields = []string{"platform", "chat_id"}
+
+// templateSeparators are the only literal characters a custom strategy may use
+const templateSeparators = ":/-_."
+
+// ValidateSessionStrategy checks a strategy name or custom template such as
+// "{platform}:{chat_id}:{sender_id}"
+func ValidateSessionStrategy(strategy string) error {
+	switch strategy {
+	case SessionPerChat, SessionPerSender, SessionPerThread:
+		return nil
+	}
+
+	matches := templateFieldPattern.FindAllStringSubmatch(strategy, -1)
+	if len(matches) == 0 {
+		return fmt.Errorf("unknown session strategy %q", strategy)
+	}
+	used := make(map[string]bool)
+	for _, m := range matches {
+		if _, ok := sessionTemplateFields[m[1]]; !ok {
+			return fmt.Errorf("unknown placeholder {%s} in session template", m[1])
+		}
+		used[m[1]] = true
+	}
+	// Keys must stay within the chat, or a template could join the session
+	// of another chat
+	for _, field := range requiredTemplateFields {
+		if !used[field] {
+			return fmt.Errorf("session template must contain {%s}", field)
+		}
+	}
+	if literal := strings.Trim(templateFieldPattern.ReplaceAllString(strategy, ""), templateSeparators); literal != "" {
+		return fmt.Errorf("session template may only separate placeholders with %q, got %q", templateSeparators, literal)
+	}
+	return nil
+}
+
+// sessionStrategyFor returns the session strategy of a chat
+func (b *Bridge) sessionStrategyFor(chatKey string) string {
+	if strategy := b.settings.get(chatKey).SessionStrategy; strategy != "" {
+		return strategy
+	}
+	return b.sessionStrategy
+}
+
+// sessionKeyFor returns the agent session a message belongs to
+func (b *Bridge) sessionKeyFor(msg *im.Message) string {
+	return sessionKey(b.sessionStrategyFor(chatKey(msg)), msg)
+}
+
+// sessionKey applies a strategy to msg. The per-chat key is the chat key
+// itself, so sessions created before strategies existed carry on.
+func sessionKey(strategy string, msg *im.Message) string {
+	switch strategy {
+	case SessionPerChat, "":
+		return chatKey(msg)
+	case SessionPerSender:
+		if msg.Sender.ID == "" {
+			return chatKey(msg)
+		}
+		return fmt.Sprintf("%s:user:%s", chatKey(msg), msg.Sender.ID)
+	case SessionPerThread:
+		if root := threadRoot(msg); root != "" {
+			return fmt.Sprintf("%s:thread:%s", chatKey(msg), root)
+		}
+		return chatKey(msg)
+	}
+
+	return templateFieldPattern.ReplaceAllStringFunc(strategy, func(field string) string {
+		if value, ok := sessionTemplateFields[strings.Trim(field, "{}")]; ok {
+			return value(msg)
+		}
+		return field
+	})
+}
+
+// threadRoot returns the thread of msg. A message outside any thread starts
+// one, since the reply to it becomes the first message of the thread.
+func threadRoot(msg *im.Message) string {
+	if msg.ThreadID != "" {
+		return msg.ThreadID
+	}
+	return msg.MessageID
+}
@@ -0,0 +1,78 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+func TestSessionKey(t *testing.T) {
+	msg := &im.Message{
+		Platform:  "feishu",
+		MessageID: "om_2",
+		ChatID:    "oc_1",
+		ChatType:  im.ChatTypeGroup,
+		Sender:    im.Sender{ID: "ou_a"},
+		ThreadID:  "om_1",
+	}
+	noThread := &im.Message{Platform: "feishu", MessageID: "om_3", ChatID: "oc_1"}
+
+	tests := []struct {
+		strategy string
+		msg      *im.Message
+		want     string
+	}{
+		{SessionPerChat, msg, "feishu:oc_1"},
+		{SessionPerSender, msg, "feishu:oc_1:user:ou_a"},
+		{SessionPerSender, noThread, "feishu:oc_1"},
+		{SessionPerThread, msg, "feishu:oc_1:thread:om_1"},
+		{SessionPerThread, noThread, "feishu:oc_1:thread:om_3"},
+		{"{platform}/{chat_id}/{chat_type}/{sender_id}", msg, "feishu/oc_1/group/ou_a"},
+	}
+	for _, tt := range tests {
+		if got := sessionKey(tt.strategy, tt.msg); got != tt.want {
+			t.Errorf("sessionKey(%q, %s) = %q, want %q", tt.strategy, tt.msg.MessageID, got, tt.want)
+		}
+	}
+}
+
+func TestValidateSessionStrategy(t *testing.T) {
+	for _, strategy := range []string{"chat", "sender", "thread", "{platform}:{chat_id}:{thread_id}"} {
+		if err := ValidateSessionStrategy(strategy); err != nil {
+			t.Errorf("ValidateSessionStrategy(%q) error: %v", strategy, err)
+		}
+	}
+	for _, strategy := range []string{"user", "fixed-key", "{platform}:{chat_id}:{nickname}", "{chat_id}:{thread_id}", "{platform}:{chat_id}:x", "feishu:oc_2"} {
+		if err := ValidateSessionStrategy(strategy); err == nil {
+			t.Errorf("ValidateSessionStrategy(%q) succeeded, want error", strategy)
+		}
+	}
+}
+
+func TestSessionStrategyPerChat(t *testing.T) {
+	b, err := NewBridge(nil, Options{SessionStrategy: SessionPerSender, Admins: []string{"ou_admin"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &im.Message{Platform: "feishu", ChatID: "oc_1", Sender: im.Sender{ID: "ou_a"}}
+
+	if got := b.sessionKeyFor(msg); got != "feishu:oc_1:user:ou_a" {
+		t.Fatalf("default sessionKeyFor() = %q", got)
+	}
+	member := &CommandRequest{ChatKey: chatKey(msg), Args: "chat", Message: msg}
+	if reply, _ := sessionCommand(b, member); !strings.Contains(reply, "管理员") {
+		t.Fatalf("member reply = %q, want a refusal", reply)
+	}
+	admin := &CommandRequest{ChatKey: chatKey(msg), Args: "chat", Message: &im.Message{Sender: im.Sender{ID: "ou_admin"}}}
+	if _, err := sessionCommand(b, admin); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.sessionKeyFor(msg); got != "feishu:oc_1" {
+		t.Fatalf("sessionKeyFor() after /session chat = %q", got)
+	}
+
+	if _, err := NewBridge(nil, Options{SessionStrategy: "bogus"}); err == nil {
+		t.Fatal("NewBridge() accepted an unknown strategy")
+	}
+}
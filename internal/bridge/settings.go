@@ -0,0 +1,86 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/fsutil"
+)
+
+// chatSettings holds preferences that users change from inside a chat
+type chatSettings struct {
+	AgentID         string `json:"agent_id,omitempty"`
+	ReplyMode       string `json:"reply_mode,omitempty"`
+	SessionStrategy string `json:"session_strategy,omitempty"`
+	TriggerMode     string `json:"trigger_mode,omitempty"`
+	Progress        string `json:"progress,omitempty"`
+}
+
+// settingsStore keeps chat settings in memory and persists them to a JSON
+// file. An empty path keeps everything in memory.
+type settingsStore struct {
+	path  string
+	mu    sync.RWMutex
+	chats map[string]chatSettings
+}
+
+func loadSettingsStore(path string) (*settingsStore, error) {
+	s := &settingsStore{
+		path:  path,
+		chats: make(map[string]chatSettings),
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &s.chats); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	return s, nil
+}
+
+func (s *settingsStore) get(chatID string) chatSettings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.chats[chatID]
+}
+
+// update changes the settings of one chat and saves the store
+func (s *settingsStore) update(chatID string, fn func(*chatSettings)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	settings := s.chats[chatID]
+	fn(&settings)
+	if settings == (chatSettings{}) {
+		delete(s.chats, chatID)
+	} else {
+		s.chats[chatID] = settings
+	}
+
+	return s.save()
+}
+
+// save must be called with s.mu held
+func (s *settingsStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.chats, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return fsutil.WriteFileAtomic(s.path, data, 0600)
+}
@@ -0,0 +1,220 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+const (
+	// partHeaderReserve leaves room for the "（1/3）" part number
+	partHeaderReserve = 16
+	// fenceCloseReserve leaves room to close a code fence cut in two
+	fenceCloseReserve = 4
+)
+
+// codeFile is a code block taken out of a reply to be sent as a file
+type codeFile struct {
+	name string
+	data []byte
+}
+
+// messageLimit returns the message size limit of m, 0 when unknown
+func messageLimit(m im.Messenger) int {
+	if limiter, ok := m.(im.Limiter); ok {
+		return limiter.MaxMessageBytes()
+	}
+	return 0
+}
+
+// splitReply splits text into parts of at most max bytes. Cuts are made at
+// blank lines or after a code block where possible; a code block that does
+// not fit in one part is closed at the cut and reopened in the next part.
+func splitReply(text string, max int) []string {
+	if max <= 0 || len(text) <= max {
+		return []string{text}
+	}
+
+	var parts []string
+	var lines []string
+	size := 0
+	// safe is the number of lines in the current part that end at a
+	// boundary where the part may be cut
+	safe := 0
+	// fence is the opening line of the code block being read, "" outside
+	fence := ""
+
+	emit := func(n int) {
+		if part := strings.Trim(strings.Join(lines[:n], "\n"), "\n"); part != "" {
+			parts = append(parts, part)
+		}
+		lines = append([]string(nil), lines[n:]...)
+		size = 0
+		for _, line := range lines {
+			size += len(line) + 1
+		}
+		safe = 0
+	}
+
+	limit := max - fenceCloseReserve
+	queue := strings.Split(text, "\n")
+	for len(queue) > 0 {
+		line := queue[0]
+		queue = queue[1:]
+
+		// A code block whose opening line leaves no room for code is
+		// reopened without its info string
+		if fence != "" && limit-len(fence)-2 < 1 {
+			fence = "```"
+		}
+
+		// A line that cannot fit even in an empty part is cut on a rune
+		// boundary; each piece then fills a part of its own. Fence lines are
+		// kept whole, and a piece holds at least one rune even when that
+		// overflows a tiny limit.
+		room := limit - 1
+		if fence != "" {
+			room -= len(fence) + 1
+		}
+		if room < 1 {
+			room = 1
+		}
+		if len(line) > room && !strings.HasPrefix(strings.TrimSpace(line), "```") {
+			cut := room
+			for cut > 0 && !utf8.RuneStart(line[cut]) {
+				cut--
+			}
+			if cut == 0 {
+				_, cut = utf8.DecodeRuneInString(line)
+			}
+			queue = append([]string{line[cut:]}, queue...)
+			line = line[:cut]
+		}
+
+		if size+len(line)+1 > limit && len(lines) > 0 && safe > 0 {
+			emit(safe)
+		}
+		if size+len(line)+1 > limit && len(lines) > 0 {
+			if fence != "" {
+				lines = append(lines, "```")
+				emit(len(lines))
+				lines = []string{fence}
+				size = len(fence) + 1
+			} else {
+				emit(len(lines))
+			}
+		}
+
+		lines = append(lines, line)
+		size += len(line) + 1
+
+		trimmed := strings.TrimSpace(line)
+		closesFence := false
+		if strings.HasPrefix(trimmed, "```") {
+			if fence == "" {
+				fence = line
+			} else {
+				fence = ""
+				closesFence = true
+			}
+		}
+		if fence == "" && (trimmed == "" || closesFence) {
+			safe = len(lines)
+		}
+	}
+	emit(len(lines))
+
+	return parts
+}
+
+// numberParts prefixes each part with its position when there are several
+func numberParts(parts []string) []string {
+	if len(parts) < 2 {
+		return parts
+	}
+	numbered := make([]string, len(parts))
+	for i, part := range parts {
+		numbered[i] = fmt.Sprintf("（%d/%d）\n%s", i+1, len(parts), part)
+	}
+	return numbered
+}
+
+// extractCodeFiles replaces code blocks longer than threshold bytes with a
+// note and returns them as files
+func extractCodeFiles(text string, threshold int) (string, []codeFile) {
+	if threshold <= 0 {
+		return text, nil
+	}
+
+	var out []string
+	var files []codeFile
+	var block []string
+	fence := ""
+
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if fence == "" {
+			if strings.HasPrefix(trimmed, "```") {
+				fence = trimmed
+				block = nil
+				continue
+			}
+			out = append(out, line)
+			continue
+		}
+
+		if !strings.HasPrefix(trimmed, "```") {
+			block = append(block, line)
+			continue
+		}
+
+		code := strings.Join(block, "\n") + "\n"
+		if len(code) > threshold {
+			name := fmt.Sprintf("code-%d.%s", len(files)+1, codeFileExt(strings.TrimPrefix(fence, "```")))
+			files = append(files, codeFile{name: name, data: []byte(code)})
+			out = append(out, fmt.Sprintf("（代码较长，已作为文件 %s 发送）", name))
+		} else {
+			out = append(out, fence)
+			out = append(out, block...)
+			out = append(out, line)
+		}
+		fence = ""
+	}
+
+	// An unterminated block is kept as it is
+	if fence != "" {
+		out = append(out, fence)
+		out = append(out, block...)
+	}
+
+	return strings.Join(out, "\n"), files
+}
+
+// codeFileExt maps a fence language to a file extension
+func codeFileExt(lang string) string {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	switch lang {
+	case "":
+		return "txt"
+	case "golang":
+		return "go"
+	case "python":
+		return "py"
+	case "javascript":
+		return "js"
+	case "typescript":
+		return "ts"
+	case "bash", "shell":
+		return "sh"
+	case "yml":
+		return "yaml"
+	}
+	for _, r := range lang {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9') {
+			return "txt"
+		}
+	}
+	return lang
+}
@@ -0,0 +1,155 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestSplitReplyKeepsShortReply(t *testing.T) {
+	parts := splitReply("hello", 100)
+	if len(parts) != 1 || parts[0] != "hello" {
+		t.Fatalf("splitReply() = %q", parts)
+	}
+}
+
+func TestSplitReplyCutsAtParagraphs(t *testing.T) {
+	paragraph := strings.Repeat("a", 30)
+	text := paragraph + "\n\n" + paragraph + "\n\n" + paragraph
+
+	parts := splitReply(text, 70)
+	if len(parts) != 2 || parts[0] != paragraph+"\n\n"+paragraph || parts[1] != paragraph {
+		t.Fatalf("splitReply() = %q", parts)
+	}
+}
+
+func TestSplitReplyDoesNotCutInsideCodeBlock(t *testing.T) {
+	code := "```go\nfunc main() {\n\tfmt.Println(1)\n}\n```"
+	text := "intro\n\n" + code + "\n\noutro"
+
+	parts := splitReply(text, len(code)+8)
+	for _, part := range parts {
+		if strings.Count(part, "```")%2 != 0 {
+			t.Fatalf("part has an unbalanced fence: %q", part)
+		}
+	}
+	if !containsPart(parts, code) {
+		t.Fatalf("splitReply() = %q, want the code block in one part", parts)
+	}
+}
+
+func TestSplitReplyReopensOversizedCodeBlock(t *testing.T) {
+	var lines []string
+	for i := 0; i < 20; i++ {
+		lines = append(lines, "line of code")
+	}
+	text := "```sh\n" + strings.Join(lines, "\n") + "\n```"
+
+	parts := splitReply(text, 80)
+	if len(parts) < 2 {
+		t.Fatalf("splitReply() = %d parts, want several", len(parts))
+	}
+	for _, part := range parts {
+		if len(part) > 80 {
+			t.Fatalf("part is %d bytes, want at most 80", len(part))
+		}
+		if !strings.HasPrefix(part, "```sh\n") || !strings.HasSuffix(part, "\n```") {
+			t.Fatalf("part is not a complete code block: %q", part)
+		}
+	}
+}
+
+func TestSplitReplySplitsLongLines(t *testing.T) {
+	text := strings.Repeat("好", 100)
+
+	parts := splitReply(text, 90)
+	if strings.Join(parts, "") != text {
+		t.Fatalf("splitReply() lost text: %q", parts)
+	}
+	for _, part := range parts {
+		if len(part) > 90 {
+			t.Fatalf("part is %d bytes, want at most 90", len(part))
+		}
+	}
+}
+
+func TestSplitReplySmallLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		max  int
+	}{
+		{"cjk max 1", "中文中文", 1},
+		{"cjk max 5", "中文中文中文中文中文", 5},
+		{"cjk max 6", "中文中文中文中文中文", 6},
+		{"cjk max 7", "中文中文中文中文中文", 7},
+		{"cjk max 8", "中文中文中文中文中文", 8},
+		{"emoji max 6", "😀😀😀😀", 6},
+		{"long fence", "```" + strings.Repeat("x", 40) + "\n中文中文\n```", 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			done := make(chan []string, 1)
+			go func() { done <- splitReply(tt.text, tt.max) }()
+
+			var parts []string
+			select {
+			case parts = <-done:
+			case <-time.After(time.Second):
+				t.Fatalf("splitReply(%q, %d) did not return", tt.text, tt.max)
+			}
+
+			var runes []string
+			for _, part := range parts {
+				if !utf8.ValidString(part) {
+					t.Fatalf("part %q is not valid UTF-8", part)
+				}
+				for _, line := range strings.Split(part, "\n") {
+					if !strings.HasPrefix(line, "```") {
+						runes = append(runes, line)
+					}
+				}
+			}
+			want := tt.text
+			if strings.HasPrefix(want, "```") {
+				want = "中文中文"
+			}
+			if got := strings.Join(runes, ""); got != want {
+				t.Fatalf("splitReply(%q, %d) = %q, lost text", tt.text, tt.max, parts)
+			}
+		})
+	}
+}
+
+func TestNumberParts(t *testing.T) {
+	parts := numberParts([]string{"a", "b"})
+	if parts[0] != "（1/2）\na" || parts[1] != "（2/2）\nb" {
+		t.Fatalf("numberParts() = %q", parts)
+	}
+	if parts := numberParts([]string{"a"}); parts[0] != "a" {
+		t.Fatalf("numberParts() of one part = %q", parts)
+	}
+}
+
+func TestExtractCodeFiles(t *testing.T) {
+	text := "看这里：\n```python\nprint(1)\nprint(2)\n```\n短的：\n```\nx\n```"
+
+	got, files := extractCodeFiles(text, 10)
+	if len(files) != 1 || files[0].name != "code-1.py" || string(files[0].data) != "print(1)\nprint(2)\n" {
+		t.Fatalf("extractCodeFiles() files = %+v", files)
+	}
+	want := "看这里：\n（代码较长，已作为文件 code-1.py 发送）\n短的：\n```\nx\n```"
+	if got != want {
+		t.Fatalf("extractCodeFiles() = %q, want %q", got, want)
+	}
+}
+
+func containsPart(parts []string, s string) bool {
+	for _, part := range parts {
+		if strings.Contains(part, s) {
+			return true
+		}
+	}
+	return false
+}
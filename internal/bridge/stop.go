@@ -0,0 +1,168 @@
+package bridge
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+// cancelledNotice ends the reply of a run that was stopped from the chat
+const cancelledNotice = "（已取消）"
+
+// abortTimeout bounds the gateway call that aborts a run
+const abortTimeout = 10 * time.Second
+
+// stopButton is shown under the reply message while the agent is running
+var stopButton = im.Button{Text: "停止", Command: "/stop"}
+
+// activeRun is an agent run that can be stopped from the chat
+type activeRun struct {
+	sessionKey string
+	msg        *im.Message
+	stream     *replyStream
+	cancel     context.CancelFunc
+
+	mu      sync.Mutex
+	runID   string
+	stopped bool
+}
+
+func (r *activeRun) isStopped() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stopped
+}
+
+// askedBy reports whether msg comes from the sender of the question
+func (r *activeRun) askedBy(msg *im.Message) bool {
+	return msg.Sender.ID != "" && msg.Sender.ID == r.msg.Sender.ID
+}
+
+// activeRuns tracks the agent run of each session. The run queue runs one
+// job per session at a time, so a session has at most one.
+type activeRuns struct {
+	mu   sync.Mutex
+	runs map[string]*activeRun
+}
+
+func newActiveRuns() *activeRuns {
+	return &activeRuns{runs: make(map[string]*activeRun)}
+}
+
+func (a *activeRuns) add(r *activeRun) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.runs[r.sessionKey] = r
+}
+
+func (a *activeRuns) remove(r *activeRun) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.runs[r.sessionKey] == r {
+		delete(a.runs, r.sessionKey)
+	}
+}
+
+// forSession returns the run of a session, nil when it is idle
+func (a *activeRuns) forSession(sessionKey string) *activeRun {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.runs[sessionKey]
+}
+
+// forMessage returns the run whose reply is shown in messageID
+func (a *activeRuns) forMessage(messageID string) *activeRun {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, r := range a.runs {
+		if r.stream.currentMessageID() == messageID {
+			return r
+		}
+	}
+	return nil
+}
+
+// stopRun aborts a run on the gateway and stops waiting for it. The run's
+// reply is then marked as cancelled. A run the gateway has not accepted yet
+// is aborted by runStarted once its ID is known, since giving up on it
+// earlier would leave it running unseen.
+func (b *Bridge) stopRun(r *activeRun) {
+	r.mu.Lock()
+	if r.stopped {
+		r.mu.Unlock()
+		return
+	}
+	r.stopped = true
+	runID := r.runID
+	r.mu.Unlock()
+
+	if runID == "" {
+		log.Printf("[Bridge] Stopping run of %s once the gateway accepts it", r.sessionKey)
+		return
+	}
+	b.abortRun(r, runID)
+}
+
+// runStarted records the gateway run ID once the run is accepted, and
+// aborts the run if it was stopped before that
+func (b *Bridge) runStarted(r *activeRun, runID string) {
+	r.mu.Lock()
+	r.runID = runID
+	stopped := r.stopped
+	r.mu.Unlock()
+
+	if stopped {
+		b.abortRun(r, runID)
+	}
+}
+
+func (b *Bridge) abortRun(r *activeRun, runID string) {
+	log.Printf("[Bridge] Stopping run %s of %s", runID, r.sessionKey)
+	ctx, cancel := context.WithTimeout(context.Background(), abortTimeout)
+	defer cancel()
+	if err := b.clawdbotClient.AbortRun(ctx, r.sessionKey, runID); err != nil {
+		log.Printf("[Bridge] Failed to abort run %s: %v", runID, err)
+	}
+	// Stop waiting even when the gateway could not end the run
+	r.cancel()
+}
+
+// runTimeoutFor returns how long to wait for a run of agentID, 0 for the
+// client default
+func (b *Bridge) runTimeoutFor(agentID string) time.Duration {
+	if timeout, ok := b.agentRunTimeouts[agentID]; ok {
+		return timeout
+	}
+	return b.runTimeout
+}
+
+func stopCommand(b *Bridge, req *CommandRequest) (string, error) {
+	msg := req.Message
+	pressed := msg.ButtonMessageID != ""
+
+	var run *activeRun
+	if pressed {
+		run = b.active.forMessage(msg.ButtonMessageID)
+	} else {
+		run = b.active.forSession(req.SessionKey)
+	}
+	switch {
+	case run == nil && pressed:
+		// The run finished before the button was pressed
+		return "", nil
+	case run == nil:
+		return "当前没有正在运行的请求。", nil
+	case !run.askedBy(msg) && !b.isAdmin(msg):
+		return "只有提问者或管理员可以停止这个请求。", nil
+	}
+
+	b.stopRun(run)
+	if pressed {
+		// The reply message itself shows that the run was cancelled
+		return "", nil
+	}
+	return "已停止当前请求。", nil
+}
@@ -0,0 +1,78 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/clawdbot"
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+func TestStopCommand(t *testing.T) {
+	// A closed client fails every abort at once; the run must be cancelled
+	// anyway
+	gateway := clawdbot.NewClient(0, "", "main")
+	gateway.Close()
+	b, err := NewBridge(gateway, Options{Admins: []string{"ou_admin"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancelled := false
+	run := &activeRun{
+		sessionKey: "s1",
+		msg:        &im.Message{Sender: im.Sender{ID: "ou_asker"}},
+		stream:     &replyStream{messageID: "om_reply"},
+		cancel:     func() { cancelled = true },
+	}
+	b.active.add(run)
+
+	stop := func(senderID, buttonMessageID string) string {
+		reply, err := stopCommand(b, &CommandRequest{
+			SessionKey: "s1",
+			Message:    &im.Message{Sender: im.Sender{ID: senderID}, ButtonMessageID: buttonMessageID},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
+
+	if reply := stop("ou_other", ""); reply != "只有提问者或管理员可以停止这个请求。" || run.isStopped() {
+		t.Fatalf("/stop from another member = %q, stopped = %v", reply, run.isStopped())
+	}
+	if reply := stop("ou_asker", "om_old"); reply != "" || run.isStopped() {
+		t.Fatalf("stop button on another message = %q, stopped = %v", reply, run.isStopped())
+	}
+	// The gateway has not accepted the run yet, so the wait goes on until
+	// it can be aborted by ID
+	if reply := stop("ou_asker", "om_reply"); reply != "" || !run.isStopped() || cancelled {
+		t.Fatalf("stop button = %q, stopped = %v, cancelled = %v", reply, run.isStopped(), cancelled)
+	}
+	b.runStarted(run, "run-1")
+	if !cancelled {
+		t.Fatal("run stopped before it started was not cancelled once started")
+	}
+
+	b.active.remove(run)
+	if reply := stop("ou_admin", ""); reply != "当前没有正在运行的请求。" {
+		t.Fatalf("/stop without a run = %q", reply)
+	}
+}
+
+func TestRunTimeoutFor(t *testing.T) {
+	b, err := NewBridge(nil, Options{
+		RunTimeout:       time.Minute,
+		AgentRunTimeouts: map[string]time.Duration{"coder": time.Hour},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := b.runTimeoutFor("main"); got != time.Minute {
+		t.Errorf("runTimeoutFor(main) = %v, want 1m", got)
+	}
+	if got := b.runTimeoutFor("coder"); got != time.Hour {
+		t.Errorf("runTimeoutFor(coder) = %v, want 1h", got)
+	}
+}
@@ -0,0 +1,296 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/clawdbot"
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+const (
+	// thinkingText is the placeholder shown until the reply starts
+	thinkingText = "正在思考…"
+
+	// streamCursor is appended to partial replies while the agent is still writing
+	streamCursor = " ▌"
+
+	// maxStreamEdits keeps streaming below Feishu's per-message edit limit,
+	// the strictest of the supported platforms, leaving room for the final update
+	maxStreamEdits = 18
+	// maxProgressEdits caps the edits that show only tool calls, so a
+	// tool-heavy run leaves the rest of maxStreamEdits to the reply
+	maxProgressEdits = maxStreamEdits / 2
+
+	// progressInterval is the time between tool progress edits when
+	// replies are not streamed
+	progressInterval = 2 * time.Second
+
+	// noReplyToken is the whole reply of an agent that chooses not to answer
+	noReplyToken = "NO_REPLY"
+	// noReplyMinPrefix is how much of noReplyToken a partial reply must
+	// match before streaming holds it back, so that short answers such as
+	// "NO" still show
+	noReplyMinPrefix = len("NO_")
+)
+
+// replyStream owns the reply message for one agent run. It posts the reply
+// early and keeps editing it as assistant deltas arrive.
+type replyStream struct {
+	bridge    *Bridge
+	messenger im.Messenger
+	updater   im.Updater      // nil when the platform cannot edit messages
+	buttons   im.ButtonSetter // nil when the platform has no buttons
+	msg       *im.Message
+	// interval is the time between edits, 0 when nothing is shown live
+	interval time.Duration
+	// streaming shows the partial reply; otherwise edits show tool calls only
+	streaming bool
+	// limit is the platform message size limit, 0 when unknown
+	limit int
+	// progress is the chat's progress mode for tool calls
+	progress string
+
+	// sendMu serializes messenger calls so the thinking placeholder and the
+	// first streamed chunk never create two messages
+	sendMu sync.Mutex
+
+	mu        sync.Mutex
+	text      string
+	tools     toolProgress
+	flushed   string
+	messageID string
+	edits     int
+	done      bool
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func newReplyStream(b *Bridge, m im.Messenger, msg *im.Message) *replyStream {
+	s := &replyStream{
+		bridge:    b,
+		messenger: m,
+		msg:       msg,
+		interval:  time.Duration(b.streamIntervalMs) * time.Millisecond,
+		streaming: b.streamIntervalMs > 0,
+		limit:     messageLimit(m),
+		progress:  b.progressModeFor(chatKey(msg)),
+		stop:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	// Tool progress is shown even when replies are not streamed
+	if !s.streaming && s.progress != ProgressOff {
+		s.interval = progressInterval
+	}
+	s.updater, _ = m.(im.Updater)
+	if s.updater != nil {
+		s.buttons, _ = m.(im.ButtonSetter)
+	}
+	return s
+}
+
+// start begins the throttled edit loop. It is a no-op when there is
+// nothing to show live or the platform cannot edit messages.
+func (s *replyStream) start() {
+	if s.interval <= 0 || s.updater == nil {
+		close(s.stopped)
+		return
+	}
+
+	go func() {
+		defer close(s.stopped)
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				s.flush()
+			}
+		}
+	}()
+}
+
+// onProgress receives stream events from the ClawdBot client. It runs on the
+// gateway reader goroutine, so it only records the text and tool calls.
+func (s *replyStream) onProgress(stream, data string) {
+	switch stream {
+	case "assistant":
+	case "tool_call", "tool_result":
+		if s.progress != ProgressOff {
+			s.mu.Lock()
+			s.tools.handle(stream, data, time.Now())
+			s.mu.Unlock()
+		}
+		return
+	default:
+		return
+	}
+
+	var streamData clawdbot.StreamData
+	if err := json.Unmarshal([]byte(data), &streamData); err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if streamData.Text != "" {
+		s.text = streamData.Text
+	} else if streamData.Delta != "" {
+		s.text += streamData.Delta
+	}
+}
+
+// showThinking posts the "thinking" placeholder unless a reply is already
+// visible. Platforms that can neither edit nor delete get no placeholder.
+func (s *replyStream) showThinking() {
+	if _, canDelete := s.messenger.(im.Deleter); s.updater == nil && !canDelete {
+		return
+	}
+
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	s.mu.Lock()
+	skip := s.done || s.messageID != ""
+	s.mu.Unlock()
+	if skip {
+		return
+	}
+
+	msgID, err := s.bridge.sendReply(context.Background(), s.messenger, s.msg, thinkingText)
+	if err != nil {
+		log.Printf("[Bridge] Failed to send thinking message: %v", err)
+		return
+	}
+
+	s.mu.Lock()
+	s.messageID = msgID
+	s.mu.Unlock()
+
+	// Buttons can only be added to a sent message, so show the stop button
+	// with an edit right away. Messages that cannot show it are left alone
+	// to save the edit.
+	if s.buttons != nil && s.buttons.SetButtons(msgID, []im.Button{stopButton}) {
+		if err := s.updater.UpdateMessage(context.Background(), msgID, thinkingText); err != nil {
+			log.Printf("[Bridge] Failed to add stop button: %v", err)
+			return
+		}
+		s.mu.Lock()
+		s.edits++
+		s.mu.Unlock()
+	}
+}
+
+// flush pushes the partial reply to the chat if it changed since the last edit
+func (s *replyStream) flush() {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	s.mu.Lock()
+	var answer string
+	if s.streaming {
+		answer = strings.TrimSpace(s.text)
+	}
+	tools := s.tools.render(s.progress)
+	// Long replies are split when they finish; until then show the first part
+	if s.limit > 0 && answer != "" {
+		answer = splitReply(answer, s.limit-partHeaderReserve-len(tools))[0]
+	}
+	// Tool calls are listed above the reply while the agent works
+	text := tools
+	if answer != "" {
+		text = strings.TrimSpace(tools + "\n\n" + answer + streamCursor)
+	}
+	messageID := s.messageID
+	skip := s.done || text == "" || text == s.flushed || s.edits >= maxStreamEdits ||
+		(answer == "" && s.edits >= maxProgressEdits) || mayBeNoReply(answer)
+	s.mu.Unlock()
+	if skip {
+		return
+	}
+
+	if messageID == "" {
+		msgID, err := s.bridge.sendReply(context.Background(), s.messenger, s.msg, text)
+		if err != nil {
+			log.Printf("[Bridge] Failed to send streaming message: %v", err)
+			return
+		}
+		messageID = msgID
+		// The stop button appears with the next edit
+		if s.buttons != nil {
+			s.buttons.SetButtons(messageID, []im.Button{stopButton})
+		}
+	} else if err := s.updater.UpdateMessage(context.Background(), messageID, text); err != nil {
+		log.Printf("[Bridge] Failed to update streaming message: %v", err)
+		return
+	}
+
+	s.mu.Lock()
+	s.messageID = messageID
+	s.flushed = text
+	s.edits++
+	s.mu.Unlock()
+}
+
+// finish stops streaming and returns the message that should carry the final reply
+func (s *replyStream) finish() string {
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	<-s.stopped
+
+	// Wait for an in-flight placeholder or edit before reading the message ID
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.done = true
+	if s.buttons != nil && s.messageID != "" {
+		s.buttons.SetButtons(s.messageID, nil)
+	}
+	return s.messageID
+}
+
+// mayBeNoReply reports whether a partial reply is the start of noReplyToken
+func mayBeNoReply(answer string) bool {
+	return len(answer) >= noReplyMinPrefix && strings.HasPrefix(noReplyToken, answer)
+}
+
+// toolSummary lists the tool calls for the final reply on platforms that
+// could not show them while the agent worked
+func (s *replyStream) toolSummary() string {
+	if s.updater != nil {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tools.render(s.progress)
+}
+
+// currentMessageID returns the message that shows the reply so far
+func (s *replyStream) currentMessageID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.messageID
+}
+
+// partial returns the reply text received so far
+func (s *replyStream) partial() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return strings.TrimSpace(s.text)
+}
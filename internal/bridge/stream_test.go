@@ -0,0 +1,296 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+// fakeUpdater is a messenger that records sent messages and edits. With
+// cards set, sent messages can show buttons.
+type fakeUpdater struct {
+	cards bool
+	// sendDelay holds every send for a while, to widen races
+	sendDelay time.Duration
+
+	mu      sync.Mutex
+	sent    []string
+	updates []string
+	buttons map[string][]im.Button
+}
+
+func (f *fakeUpdater) Platform() string { return "fake" }
+
+func (f *fakeUpdater) Start(ctx context.Context, handler im.Handler) error { return nil }
+
+func (f *fakeUpdater) SendMessage(ctx context.Context, chatID, text string) (string, error) {
+	time.Sleep(f.sendDelay)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, text)
+	return fmt.Sprintf("om_%d", len(f.sent)), nil
+}
+
+func (f *fakeUpdater) UpdateMessage(ctx context.Context, messageID, text string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.updates = append(f.updates, text)
+	return nil
+}
+
+func (f *fakeUpdater) SetButtons(messageID string, buttons []im.Button) bool {
+	if !f.cards {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.buttons == nil {
+		f.buttons = make(map[string][]im.Button)
+	}
+	f.buttons[messageID] = buttons
+	return true
+}
+
+func (f *fakeUpdater) counts() (sent, updates int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.sent), len(f.updates)
+}
+
+// newTestStream starts a stream that edits every interval, or only when
+// flushed by the test when interval is 0
+func newTestStream(t *testing.T, m im.Messenger, interval time.Duration) *replyStream {
+	t.Helper()
+	b, err := NewBridge(nil, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newReplyStream(b, m, &im.Message{Platform: "fake", ChatID: "oc_1"})
+	s.interval = interval
+	s.streaming = true
+	s.start()
+	return s
+}
+
+func TestShowThinkingAddsStopButtonToCardsOnly(t *testing.T) {
+	text := &fakeUpdater{}
+	s := newTestStream(t, text, 0)
+	s.showThinking()
+	if sent, updates := text.counts(); sent != 1 || updates != 0 || s.edits != 0 {
+		t.Fatalf("text message: sent %d, updated %d, edits %d; want one send and no edit", sent, updates, s.edits)
+	}
+
+	card := &fakeUpdater{cards: true}
+	s = newTestStream(t, card, 0)
+	s.showThinking()
+	if sent, updates := card.counts(); sent != 1 || updates != 1 || s.edits != 1 {
+		t.Fatalf("card: sent %d, updated %d, edits %d; want one send and one edit", sent, updates, s.edits)
+	}
+	if buttons := card.buttons["om_1"]; len(buttons) != 1 || buttons[0] != stopButton {
+		t.Fatalf("card buttons = %v, want the stop button", buttons)
+	}
+}
+
+// write replaces the reply text of s as an assistant event would
+func write(s *replyStream, text string) {
+	s.onProgress("assistant", fmt.Sprintf(`{"text":%q}`, text))
+}
+
+func TestStreamEditsOnlyWhenTextChanges(t *testing.T) {
+	m := &fakeUpdater{}
+	s := newTestStream(t, m, 0)
+
+	write(s, "你好")
+	s.flush()
+	s.flush()
+	write(s, "你好，世界")
+	s.flush()
+
+	if sent, updates := m.counts(); sent != 1 || updates != 1 {
+		t.Fatalf("sent %d, updated %d; want one send and one edit", sent, updates)
+	}
+	if got := m.updates[0]; got != "你好，世界"+streamCursor {
+		t.Fatalf("edit = %q, want the new text with the cursor", got)
+	}
+}
+
+func TestStreamLoopFlushesOnInterval(t *testing.T) {
+	m := &fakeUpdater{}
+	s := newTestStream(t, m, 20*time.Millisecond)
+
+	// Deltas arriving faster than the interval are batched into few edits
+	for i := 0; i < 50; i++ {
+		s.onProgress("assistant", `{"delta":"字"}`)
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(3 * s.interval)
+	s.finish()
+
+	sent, updates := m.counts()
+	if sent != 1 || updates == 0 || sent+updates >= 50 {
+		t.Fatalf("sent %d, updated %d; want one send and a few batched edits", sent, updates)
+	}
+	if last := m.updates[len(m.updates)-1]; last != strings.Repeat("字", 50)+streamCursor {
+		t.Fatalf("last edit = %q, want the whole text", last)
+	}
+}
+
+func TestStreamStopsAtEditLimit(t *testing.T) {
+	m := &fakeUpdater{}
+	s := newTestStream(t, m, 0)
+
+	for i := 0; i < maxStreamEdits+5; i++ {
+		write(s, strings.Repeat("字", i+1))
+		s.flush()
+	}
+	if sent, updates := m.counts(); sent+updates != maxStreamEdits {
+		t.Fatalf("sent %d, updated %d; want %d calls in all", sent, updates, maxStreamEdits)
+	}
+}
+
+func TestStreamFinish(t *testing.T) {
+	m := &fakeUpdater{cards: true}
+	s := newTestStream(t, m, 0)
+
+	write(s, "你好")
+	s.flush()
+	if buttons := m.buttons["om_1"]; len(buttons) != 1 {
+		t.Fatalf("buttons while streaming = %v, want the stop button", buttons)
+	}
+
+	if id := s.finish(); id != "om_1" {
+		t.Fatalf("finish() = %q, want om_1", id)
+	}
+	if buttons := m.buttons["om_1"]; buttons != nil {
+		t.Fatalf("buttons after finish = %v, want none", buttons)
+	}
+
+	// Nothing is posted once the reply belongs to the caller
+	write(s, "你好，世界")
+	s.flush()
+	s.showThinking()
+	if sent, updates := m.counts(); sent != 1 || updates != 0 {
+		t.Fatalf("sent %d, updated %d after finish; want the first send only", sent, updates)
+	}
+	s.finish()
+}
+
+func TestStreamPlaceholderRacesFirstChunk(t *testing.T) {
+	m := &fakeUpdater{sendDelay: 20 * time.Millisecond}
+	s := newTestStream(t, m, 0)
+	write(s, "你好")
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); s.showThinking() }()
+	go func() { defer wg.Done(); s.flush() }()
+	wg.Wait()
+
+	if sent, _ := m.counts(); sent != 1 {
+		t.Fatalf("sent %d messages, want the placeholder and the chunk to share one", sent)
+	}
+	if id := s.finish(); id != "om_1" {
+		t.Fatalf("finish() = %q, want om_1", id)
+	}
+}
+
+func TestStreamHoldsBackNoReply(t *testing.T) {
+	tests := []struct {
+		text string
+		show bool
+	}{
+		{"N", true},
+		{"NO", true},
+		{"NO_", false},
+		{"NO_REP", false},
+		{"NO_REPLY", false},
+		{"NO_REPLY is a token", true},
+		{"No problem", true},
+	}
+	for _, tt := range tests {
+		m := &fakeUpdater{}
+		s := newTestStream(t, m, 0)
+		write(s, tt.text)
+		s.flush()
+		if sent, _ := m.counts(); (sent == 1) != tt.show {
+			t.Errorf("partial reply %q: sent %d, want shown = %v", tt.text, sent, tt.show)
+		}
+	}
+}
+
+// call records a tool call of s as a tool_call event would
+func call(s *replyStream, id, name string) {
+	s.onProgress("tool_call", fmt.Sprintf(`{"toolCallId":%q,"name":%q}`, id, name))
+}
+
+func TestProgressShownWithoutStreaming(t *testing.T) {
+	b, err := NewBridge(nil, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &fakeUpdater{}
+	s := newReplyStream(b, m, &im.Message{Platform: "fake", ChatID: "oc_1"})
+	if s.streaming || s.interval != progressInterval {
+		t.Fatalf("streaming = %v, interval = %v; want tool progress every %v", s.streaming, s.interval, progressInterval)
+	}
+	s.interval = 10 * time.Millisecond
+	s.start()
+
+	call(s, "c1", "read_file")
+	write(s, "日志里没有错误")
+	time.Sleep(5 * s.interval)
+	s.finish()
+
+	if len(m.sent) != 1 || !strings.Contains(m.sent[0], "read_file") {
+		t.Fatalf("sent %q, want the tool progress", m.sent)
+	}
+	for _, text := range append(m.sent, m.updates...) {
+		if strings.Contains(text, "日志") {
+			t.Fatalf("posted %q, want the reply held until the run finishes", text)
+		}
+	}
+}
+
+func TestProgressEditsLeaveRoomForReply(t *testing.T) {
+	m := &fakeUpdater{}
+	s := newTestStream(t, m, 0)
+
+	for i := 0; i < maxStreamEdits; i++ {
+		call(s, fmt.Sprintf("c%d", i), "exec")
+		s.flush()
+	}
+	if sent, updates := m.counts(); sent+updates != maxProgressEdits {
+		t.Fatalf("sent %d, updated %d; want %d tool progress calls", sent, updates, maxProgressEdits)
+	}
+
+	write(s, "完成")
+	s.flush()
+	if _, updates := m.counts(); !strings.HasSuffix(m.updates[updates-1], "完成"+streamCursor) {
+		t.Fatalf("last edit = %q, want the reply", m.updates[updates-1])
+	}
+}
+
+func TestToolSummaryOnlyWithoutEdits(t *testing.T) {
+	b, err := NewBridge(nil, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &im.Message{Platform: "fake", ChatID: "oc_1"}
+
+	plain := newReplyStream(b, &fakeReplier{}, msg)
+	call(plain, "c1", "read_file")
+	if summary := plain.toolSummary(); !strings.Contains(summary, "read_file") {
+		t.Fatalf("toolSummary() without edits = %q, want the tool calls", summary)
+	}
+
+	edits := newReplyStream(b, &fakeUpdater{}, msg)
+	call(edits, "c1", "read_file")
+	if summary := edits.toolSummary(); summary != "" {
+		t.Fatalf("toolSummary() with edits = %q, want nothing", summary)
+	}
+}
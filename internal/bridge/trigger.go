@@ -0,0 +1,149 @@
+package bridge
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+// Trigger modes decide which group messages reach the agent. Private chats
+// always do.
+const (
+	// TriggerMentionOnly answers only when the bot is mentioned
+	TriggerMentionOnly = "mention_only"
+	// TriggerHeuristic also answers messages that look like a request, such
+	// as questions, action verbs or the bot's name
+	TriggerHeuristic = "heuristic"
+	// TriggerAlways answers every message
+	TriggerAlways = "always"
+	// TriggerNever ignores every message except commands
+	TriggerNever = "never"
+)
+
+var (
+	questionWordPattern = regexp.MustCompile(`\b(?:why|how|what|when|where|who|help)\b`)
+
+	defaultBotNames    = []string{"alen", "clawdbot", "bot", "助手", "智能体"}
+	defaultActionVerbs = []string{
+		"帮", "麻烦", "请", "能否", "可以", "解释", "看看",
+		"排查", "分析", "总结", "写", "改", "修", "查", "对比", "翻译",
+	}
+)
+
+// TriggerRules configures the heuristic trigger mode. A nil list uses the
+// built-in defaults, an empty list disables that rule.
+type TriggerRules struct {
+	// BotNames trigger when a message starts with one of them, e.g. "助手，..."
+	BotNames []string
+	// Keywords trigger when a message contains one of them
+	Keywords []string
+	// Patterns are regular expressions matched against the message
+	Patterns []string
+	// ActionVerbs trigger when a message contains one of them, e.g. "帮我"
+	ActionVerbs []string
+}
+
+// ValidTriggerMode reports whether mode is one of the trigger modes
+func ValidTriggerMode(mode string) bool {
+	switch mode {
+	case TriggerMentionOnly, TriggerHeuristic, TriggerAlways, TriggerNever:
+		return true
+	}
+	return false
+}
+
+// triggerRules are compiled TriggerRules
+type triggerRules struct {
+	botName     *regexp.Regexp
+	keywords    []string
+	patterns    []*regexp.Regexp
+	actionVerbs []string
+}
+
+func newTriggerRules(rules TriggerRules) (*triggerRules, error) {
+	names := rules.BotNames
+	if names == nil {
+		names = defaultBotNames
+	}
+	actionVerbs := rules.ActionVerbs
+	if actionVerbs == nil {
+		actionVerbs = defaultActionVerbs
+	}
+
+	t := &triggerRules{actionVerbs: actionVerbs}
+	if len(names) > 0 {
+		quoted := make([]string, len(names))
+		for i, name := range names {
+			quoted[i] = regexp.QuoteMeta(strings.ToLower(name))
+		}
+		t.botName = regexp.MustCompile(`^(?:` + strings.Join(quoted, "|") + `)(?:$|[\s,:，：])`)
+	}
+	for _, keyword := range rules.Keywords {
+		t.keywords = append(t.keywords, strings.ToLower(keyword))
+	}
+	for _, pattern := range rules.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trigger pattern %q: %w", pattern, err)
+		}
+		t.patterns = append(t.patterns, re)
+	}
+
+	return t, nil
+}
+
+// shouldRespond decides whether a group message in the given mode is
+// addressed to the bot
+func (t *triggerRules) shouldRespond(mode, text string, mentions []im.Mention) bool {
+	switch mode {
+	case TriggerAlways:
+		return true
+	case TriggerNever:
+		return false
+	}
+
+	// Always respond when the bot itself is mentioned
+	if mentionsBot(mentions) {
+		return true
+	}
+	if mode == TriggerMentionOnly {
+		return false
+	}
+
+	lowerText := strings.ToLower(text)
+
+	// Question marks
+	if strings.HasSuffix(text, "?") || strings.HasSuffix(text, "？") {
+		return true
+	}
+
+	if questionWordPattern.MatchString(lowerText) {
+		return true
+	}
+
+	if containsAny(text, t.actionVerbs) || containsAny(lowerText, t.keywords) {
+		return true
+	}
+
+	if t.botName != nil && t.botName.MatchString(lowerText) {
+		return true
+	}
+
+	for _, re := range t.patterns {
+		if re.MatchString(text) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// triggerModeFor returns the trigger mode of a chat
+func (b *Bridge) triggerModeFor(chatKey string) string {
+	if mode := b.settings.get(chatKey).TriggerMode; mode != "" {
+		return mode
+	}
+	return b.triggerMode
+}
@@ -0,0 +1,100 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+func TestTriggerModes(t *testing.T) {
+	rules, err := newTriggerRules(TriggerRules{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	botMention := []im.Mention{{ID: "ou_bot", Bot: true}}
+
+	testCases := []struct {
+		mode     string
+		text     string
+		mentions []im.Mention
+		want     bool
+	}{
+		{mode: TriggerMentionOnly, text: "hello", mentions: botMention, want: true},
+		{mode: TriggerMentionOnly, text: "can you help?", want: false},
+		{mode: TriggerAlways, text: "今天中午吃什么", want: true},
+		{mode: TriggerNever, text: "hello", mentions: botMention, want: false},
+	}
+
+	for _, tc := range testCases {
+		if got := rules.shouldRespond(tc.mode, tc.text, tc.mentions); got != tc.want {
+			t.Fatalf("shouldRespond(%s, %q) = %v, want %v", tc.mode, tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestCustomTriggerRules(t *testing.T) {
+	rules, err := newTriggerRules(TriggerRules{
+		BotNames:    []string{"小运维"},
+		Keywords:    []string{"Oncall"},
+		Patterns:    []string{`^INC-\d+`},
+		ActionVerbs: []string{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for text, want := range map[string]bool{
+		"小运维，看下告警":        true,
+		"谁是今天的 oncall":    true,
+		"INC-1234 已恢复":    true,
+		"bot: status":     false,
+		"麻烦大家填一下表格":       false,
+		"帮我订个会议室":         false,
+		"how do I deploy": true,
+	} {
+		if got := rules.shouldRespond(TriggerHeuristic, text, nil); got != want {
+			t.Fatalf("shouldRespond(%q) = %v, want %v", text, got, want)
+		}
+	}
+
+	if _, err := newTriggerRules(TriggerRules{Patterns: []string{"("}}); err == nil {
+		t.Fatal("newTriggerRules() accepted an invalid pattern")
+	}
+}
+
+func TestTriggerCommand(t *testing.T) {
+	dir := t.TempDir()
+	b, err := NewBridge(nil, Options{StateDir: dir, Admins: []string{"ou_admin"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	member := &CommandRequest{ChatKey: "feishu:oc_1", Args: "always", Message: &im.Message{Sender: im.Sender{ID: "ou_member"}}}
+	if reply, _ := triggerCommand(b, member); !strings.Contains(reply, "管理员") {
+		t.Fatalf("member reply = %q, want a refusal", reply)
+	}
+	if mode := b.triggerModeFor("feishu:oc_1"); mode != TriggerHeuristic {
+		t.Fatalf("mode after member command = %s, want %s", mode, TriggerHeuristic)
+	}
+
+	admin := &CommandRequest{ChatKey: "feishu:oc_1", Args: "mention_only", Message: &im.Message{Sender: im.Sender{ID: "ou_x", UnionID: "ou_admin"}}}
+	if _, err := triggerCommand(b, admin); err != nil {
+		t.Fatal(err)
+	}
+
+	// The mode survives a restart
+	b, err = NewBridge(nil, Options{StateDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := b.triggerModeFor("feishu:oc_1"); mode != TriggerMentionOnly {
+		t.Fatalf("mode after restart = %s, want %s", mode, TriggerMentionOnly)
+	}
+
+	// Without configured admins nobody may change it
+	admin.Args = "always"
+	if reply, _ := triggerCommand(b, admin); !strings.Contains(reply, "管理员") {
+		t.Fatalf("reply without admins = %q, want a refusal", reply)
+	}
+}
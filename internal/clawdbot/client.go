@@ -1,9 +1,9 @@
 package clawdbot
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"sync"
 	"time"
 
@@ -11,12 +11,27 @@ import (
 	"github.com/gorilla/websocket"
 )
 
-// Client is a ClawdBot Gateway WebSocket client
+// Client is a ClawdBot Gateway WebSocket client. It keeps one authenticated
+// connection open and multiplexes all requests and agent runs over it.
 type Client struct {
 	port    int
 	token   string
 	agentID string
+
+	startOnce sync.Once
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	// writeMu serializes frames written to the connection
+	writeMu sync.Mutex
+
 	mu      sync.Mutex
+	conn    *websocket.Conn
+	ready   chan struct{} // closed once conn is usable
+	lastErr error
+	pending map[string]chan result
+	runs    map[string]*run
+	orphans map[string]*orphanEvents
 }
 
 // NewClient creates a new ClawdBot Gateway client
@@ -25,9 +40,19 @@ func NewClient(port int, token, agentID string) *Client {
 		port:    port,
 		token:   token,
 		agentID: agentID,
+		closed:  make(chan struct{}),
+		ready:   make(chan struct{}),
+		pending: make(map[string]chan result),
+		runs:    make(map[string]*run),
+		orphans: make(map[string]*orphanEvents),
 	}
 }
 
+// AgentID returns the default agent used when a request does not name one
+func (c *Client) AgentID() string {
+	return c.agentID
+}
+
 // Request represents a request to the gateway
 type Request struct {
 	Type   string      `json:"type"`
@@ -78,11 +103,22 @@ type AuthInfo struct {
 
 // AgentParams contains agent request parameters
 type AgentParams struct {
-	Message        string `json:"message"`
-	AgentID        string `json:"agentId"`
-	SessionKey     string `json:"sessionKey"`
-	Deliver        bool   `json:"deliver"`
-	IdempotencyKey string `json:"idempotencyKey"`
+	Message        string       `json:"message"`
+	AgentID        string       `json:"agentId"`
+	SessionKey     string       `json:"sessionKey"`
+	Deliver        bool         `json:"deliver"`
+	IdempotencyKey string       `json:"idempotencyKey"`
+	Attachments    []Attachment `json:"attachments,omitempty"`
+}
+
+// Attachment is a file sent to the agent along with the message
+type Attachment struct {
+	// Type is "image" or "file"
+	Type     string `json:"type"`
+	MimeType string `json:"mimeType"`
+	FileName string `json:"fileName,omitempty"`
+	// Content is the base64-encoded file content
+	Content string `json:"content"`
 }
 
 // AgentPayload contains the agent response payload
@@ -105,310 +141,197 @@ type StreamData struct {
 	Message string `json:"message,omitempty"`
 }
 
-// AskClawdbot sends a message to ClawdBot and returns the response
-func (c *Client) AskClawdbot(text, sessionKey string, onProgress func(stream, data string)) (string, error) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// ToolData is the data of tool_call and tool_result events. A result
+// carries the ID of the call it answers.
+type ToolData struct {
+	ToolCallID string          `json:"toolCallId,omitempty"`
+	Name       string          `json:"name,omitempty"`
+	Args       json.RawMessage `json:"args,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	IsError    bool            `json:"isError,omitempty"`
+}
 
-	url := fmt.Sprintf("ws://127.0.0.1:%d", c.port)
-	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
-	if err != nil {
-		return "", fmt.Errorf("failed to connect to gateway: %w", err)
-	}
-	defer conn.Close()
-
-	var runID string
-	var buffer string
-	responseChan := make(chan string, 1)
-	errorChan := make(chan error, 1)
-
-	// Message reader goroutine
-	go func() {
-		for {
-			_, message, err := conn.ReadMessage()
-			if err != nil {
-				return
-			}
+// run tracks one agent run and collects its events. Events are delivered
+// in order while the client's mu is held.
+type run struct {
+	onProgress func(stream, data string)
+	buffer     string
 
-			log.Printf("[Clawdbot] RECEIVED MESSAGE: %s", string(message))
+	once   sync.Once
+	done   chan struct{}
+	result string
+	err    error
+}
 
-			var resp Response
-			if err := json.Unmarshal(message, &resp); err != nil {
-				continue
-			}
-			log.Printf("[Clawdbot] RECEIVED MESSAGE: type=%s, event=%s, id=%s", resp.Type, resp.Event, resp.ID)
-
-			// Step 1: Handle connect challenge
-			if resp.Type == "event" && resp.Event == "connect.challenge" {
-				connectReq := Request{
-					Type:   "req",
-					ID:     "connect",
-					Method: "connect",
-					Params: ConnectParams{
-						MinProtocol: 3,
-						MaxProtocol: 3,
-						Client: ClientInfo{
-							ID:       "gateway-client",
-							Version:  "0.2.0",
-							Platform: "linux",
-							Mode:     "backend",
-						},
-						Role:   "operator",
-						Scopes: []string{"operator.read", "operator.write", "operator.admin"},
-						Auth: AuthInfo{
-							Token: c.token,
-						},
-						Locale:    "zh-CN",
-						UserAgent: "clawdbot-bridge-go",
-					},
-				}
-
-				if err := conn.WriteJSON(connectReq); err != nil {
-					errorChan <- fmt.Errorf("failed to send connect request: %w", err)
-					return
-				}
-				continue
-			}
+func newRun(onProgress func(stream, data string)) *run {
+	return &run{
+		onProgress: onProgress,
+		done:       make(chan struct{}),
+	}
+}
 
-			// Step 2: Handle connect response
-			if resp.Type == "res" && resp.ID == "connect" {
-				if !resp.OK {
-					errMsg := "connect failed"
-					if resp.Error != nil {
-						errMsg = resp.Error.Message
-					}
-					errorChan <- fmt.Errorf(errMsg)
-					return
-				}
-
-				// Send agent request
-				agentReq := Request{
-					Type:   "req",
-					ID:     "agent",
-					Method: "agent",
-					Params: AgentParams{
-						Message:        text,
-						AgentID:        c.agentID,
-						SessionKey:     sessionKey,
-						Deliver:        true,
-						IdempotencyKey: uuid.New().String(),
-					},
-				}
-
-				if err := conn.WriteJSON(agentReq); err != nil {
-					errorChan <- fmt.Errorf("failed to send agent request: %w", err)
-					return
-				}
-				continue
-			}
+// finish completes the run once; later calls are ignored
+func (r *run) finish(result string, err error) {
+	r.once.Do(func() {
+		r.result = result
+		r.err = err
+		close(r.done)
+	})
+}
 
-			// Step 3: Handle agent response
-			if resp.Type == "res" && resp.ID == "agent" {
-				if !resp.OK {
-					errMsg := "agent error"
-					if resp.Error != nil {
-						errMsg = resp.Error.Message
-					}
-					errorChan <- fmt.Errorf(errMsg)
-					return
-				}
-
-				var payload AgentPayload
-				if err := json.Unmarshal(resp.Payload, &payload); err == nil {
-					runID = payload.RunID
-				}
-				continue
+// handle applies one agent event to the run
+func (r *run) handle(event EventPayload) {
+	switch event.Stream {
+	case "assistant":
+		if r.onProgress != nil {
+			r.onProgress("assistant", string(event.Data))
+		}
+		var streamData StreamData
+		if err := json.Unmarshal(event.Data, &streamData); err == nil {
+			if streamData.Text != "" {
+				r.buffer = streamData.Text
+			} else if streamData.Delta != "" {
+				r.buffer += streamData.Delta
 			}
+		}
 
-			// Step 4: Handle agent events
-			if resp.Type == "event" && resp.Event == "agent" {
-				var eventPayload EventPayload
-				if err := json.Unmarshal(resp.Payload, &eventPayload); err != nil {
-					continue
-				}
-
-				// Check runID matches if we have one
-				if runID != "" && eventPayload.RunID != runID {
-					continue
-				}
-
-				// Handle assistant stream
-				if eventPayload.Stream == "assistant" {
-					if onProgress != nil {
-						// Non-blocking call
-						go onProgress("assistant", string(eventPayload.Data))
-					}
-					var streamData StreamData
-					if err := json.Unmarshal(eventPayload.Data, &streamData); err == nil {
-						if streamData.Text != "" {
-							buffer = streamData.Text
-						} else if streamData.Delta != "" {
-							buffer += streamData.Delta
-						}
-					}
-					continue
-				}
-
-				// Handle thought stream
-				if eventPayload.Stream == "thought" {
-					if onProgress != nil {
-						// Non-blocking call
-						go onProgress("thought", string(eventPayload.Data))
-					}
-					continue
-				}
-
-				// Handle tool_call stream
-				if eventPayload.Stream == "tool_call" {
-					if onProgress != nil {
-						// Non-blocking call
-						go onProgress("tool_call", string(eventPayload.Data))
-					}
-					continue
-				}
-
-				// Handle tool_result stream
-				if eventPayload.Stream == "tool_result" {
-					if onProgress != nil {
-						// Non-blocking call
-						go onProgress("tool_result", string(eventPayload.Data))
-					}
-					continue
-				}
-
-				// Handle lifecycle stream
-				if eventPayload.Stream == "lifecycle" {
-					var streamData StreamData
-					if err := json.Unmarshal(eventPayload.Data, &streamData); err == nil {
-						if streamData.Phase == "end" {
-							responseChan <- buffer
-							return
-						}
-						if streamData.Phase == "error" {
-							errMsg := "agent error"
-							if streamData.Message != "" {
-								errMsg = streamData.Message
-							}
-							errorChan <- fmt.Errorf(errMsg)
-							return
-						}
-					}
-				}
-			}
+	case "thought", "tool_call", "tool_result":
+		if r.onProgress != nil {
+			r.onProgress(event.Stream, string(event.Data))
 		}
-	}()
 
-	// Wait for response or timeout
-	select {
-	case result := <-responseChan:
-		return result, nil
-	case err := <-errorChan:
-		return "", err
-	case <-time.After(15 * time.Minute):
-		return "", fmt.Errorf("timeout waiting for response")
+	case "lifecycle":
+		var streamData StreamData
+		if err := json.Unmarshal(event.Data, &streamData); err != nil {
+			return
+		}
+		if streamData.Phase == "end" {
+			r.finish(r.buffer, nil)
+		}
+		if streamData.Phase == "error" {
+			errMsg := "agent error"
+			if streamData.Message != "" {
+				errMsg = streamData.Message
+			}
+			r.finish("", fmt.Errorf("%s", errMsg))
+		}
 	}
 }
 
-// ResetSession resets a session
-func (c *Client) ResetSession(sessionKey string) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// DefaultRunTimeout is how long Ask waits for a run that does not set its
+// own timeout
+const DefaultRunTimeout = 15 * time.Minute
+
+// AskRequest describes one agent run
+type AskRequest struct {
+	Text       string
+	SessionKey string
+	// AgentID overrides the client's default agent when set
+	AgentID     string
+	Attachments []Attachment
+	// Timeout bounds the wait for the reply, 0 uses DefaultRunTimeout
+	Timeout time.Duration
+	// OnStart is called with the run ID once the gateway accepts the run
+	OnStart func(runID string)
+}
 
-	url := fmt.Sprintf("ws://127.0.0.1:%d", c.port)
-	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
-	if err != nil {
-		return fmt.Errorf("failed to connect to gateway: %w", err)
-	}
-	defer conn.Close()
+// AskClawdbot sends a message to ClawdBot and returns the response.
+// onProgress is called in order and must not block.
+func (c *Client) AskClawdbot(ctx context.Context, text, sessionKey string, onProgress func(stream, data string)) (string, error) {
+	return c.Ask(ctx, AskRequest{Text: text, SessionKey: sessionKey}, onProgress)
+}
 
-	errorChan := make(chan error, 1)
-	doneChan := make(chan bool, 1)
+// Ask runs the agent for req and returns the final reply. It stops waiting
+// and returns ctx.Err() when ctx is done.
+func (c *Client) Ask(ctx context.Context, req AskRequest, onProgress func(stream, data string)) (string, error) {
+	agentID := req.AgentID
+	if agentID == "" {
+		agentID = c.agentID
+	}
 
-	go func() {
-		for {
-			_, message, err := conn.ReadMessage()
-			if err != nil {
-				return
-			}
+	// The idempotency key doubles as a provisional run ID so events that
+	// arrive before the agent response are not lost
+	idempotencyKey := uuid.New().String()
+	r := newRun(onProgress)
+	c.registerRun(idempotencyKey, r)
+	defer c.unregisterRun(r)
+
+	resp, err := c.call(ctx, "agent", AgentParams{
+		Message:        req.Text,
+		AgentID:        agentID,
+		SessionKey:     req.SessionKey,
+		Deliver:        true,
+		IdempotencyKey: idempotencyKey,
+		Attachments:    req.Attachments,
+	}, 30*time.Second)
+	if err != nil {
+		return "", err
+	}
+	if !resp.OK {
+		return "", responseError(resp, "agent error")
+	}
 
-			var resp Response
-			if err := json.Unmarshal(message, &resp); err != nil {
-				continue
-			}
+	runID := idempotencyKey
+	var payload AgentPayload
+	if err := json.Unmarshal(resp.Payload, &payload); err == nil && payload.RunID != "" && payload.RunID != idempotencyKey {
+		runID = payload.RunID
+		c.registerRun(runID, r)
+	}
+	if req.OnStart != nil {
+		req.OnStart(runID)
+	}
 
-			if resp.Type == "event" && resp.Event == "connect.challenge" {
-				connectReq := Request{
-					Type:   "req",
-					ID:     "connect",
-					Method: "connect",
-					Params: ConnectParams{
-						MinProtocol: 3,
-						MaxProtocol: 3,
-						Client: ClientInfo{
-							ID:       "gateway-client",
-							Version:  "0.2.0",
-							Platform: "linux",
-							Mode:     "backend",
-						},
-						Role:   "operator",
-						Scopes: []string{"operator.read", "operator.write", "operator.admin"},
-						Auth: AuthInfo{
-							Token: c.token,
-						},
-						Locale:    "zh-CN",
-						UserAgent: "clawdbot-bridge-go",
-					},
-				}
-				if err := conn.WriteJSON(connectReq); err != nil {
-					errorChan <- fmt.Errorf("failed to send connect request: %w", err)
-					return
-				}
-				continue
-			}
+	timeout := req.Timeout
+	if timeout <= 0 {
+		timeout = DefaultRunTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
 
-			if resp.Type == "res" && resp.ID == "connect" {
-				if !resp.OK {
-					errorChan <- fmt.Errorf("connect failed")
-					return
-				}
-
-				// Send reset request
-				resetReq := Request{
-					Type:   "req",
-					ID:     "reset",
-					Method: "sessions.reset",
-					Params: map[string]string{
-						"key": sessionKey,
-					},
-				}
-				if err := conn.WriteJSON(resetReq); err != nil {
-					errorChan <- fmt.Errorf("failed to send reset request: %w", err)
-					return
-				}
-				continue
-			}
+	// Wait for response or timeout
+	select {
+	case <-r.done:
+		return r.result, r.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-timer.C:
+		return "", fmt.Errorf("timeout waiting for response after %v", timeout)
+	}
+}
 
-			if resp.Type == "res" && resp.ID == "reset" {
-				if !resp.OK {
-					errMsg := "reset failed"
-					if resp.Error != nil {
-						errMsg = resp.Error.Message
-					}
-					errorChan <- fmt.Errorf(errMsg)
-				} else {
-					doneChan <- true
-				}
-				return
-			}
-		}
-	}()
+// AbortRun asks the gateway to stop a run of a session. The run's Ask
+// returns once the gateway reports the run as ended.
+func (c *Client) AbortRun(ctx context.Context, sessionKey, runID string) error {
+	resp, err := c.call(ctx, "chat.abort", map[string]string{
+		"sessionKey": sessionKey,
+		"runId":      runID,
+	}, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	if !resp.OK {
+		return responseError(resp, "abort failed")
+	}
+	return nil
+}
 
-	select {
-	case <-doneChan:
-		return nil
-	case err := <-errorChan:
+// ResetSession resets a session
+func (c *Client) ResetSession(ctx context.Context, sessionKey string) error {
+	resp, err := c.call(ctx, "sessions.reset", map[string]string{
+		"key": sessionKey,
+	}, 10*time.Second)
+	if err != nil {
 		return err
-	case <-time.After(10 * time.Second):
-		return fmt.Errorf("timeout waiting for reset")
 	}
+	if !resp.OK {
+		return responseError(resp, "reset failed")
+	}
+	return nil
+}
+
+func responseError(resp *Response, fallback string) error {
+	if resp.Error != nil && resp.Error.Message != "" {
+		return fmt.Errorf("%s", resp.Error.Message)
+	}
+	return fmt.Errorf("%s", fallback)
 }
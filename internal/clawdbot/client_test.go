@@ -0,0 +1,348 @@
+package clawdbot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeGateway is a minimal gateway that answers the handshake and streams
+// a reply for every agent request
+type fakeGateway struct {
+	server      *httptest.Server
+	connections int32
+
+	// resumed are runs that finish on the next connection
+	mu      sync.Mutex
+	resumed []string
+}
+
+func newFakeGateway(t *testing.T) *fakeGateway {
+	t.Helper()
+
+	g := &fakeGateway{}
+	upgrader := websocket.Upgrader{}
+
+	g.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		atomic.AddInt32(&g.connections, 1)
+
+		var writeMu sync.Mutex
+		write := func(v interface{}) {
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			conn.WriteJSON(v)
+		}
+
+		write(map[string]interface{}{"type": "event", "event": "connect.challenge"})
+
+		for {
+			var req struct {
+				ID     string          `json:"id"`
+				Method string          `json:"method"`
+				Params json.RawMessage `json:"params"`
+			}
+			if err := conn.ReadJSON(&req); err != nil {
+				return
+			}
+
+			switch req.Method {
+			case "connect":
+				write(map[string]interface{}{"type": "res", "id": req.ID, "ok": true})
+
+				g.mu.Lock()
+				resumed := g.resumed
+				g.resumed = nil
+				g.mu.Unlock()
+				for _, runID := range resumed {
+					for _, payload := range []map[string]interface{}{
+						{"runId": runID, "stream": "assistant", "data": map[string]string{"delta": "resumed"}},
+						{"runId": runID, "stream": "lifecycle", "data": map[string]string{"phase": "end"}},
+					} {
+						write(map[string]interface{}{"type": "event", "event": "agent", "payload": payload})
+					}
+				}
+
+			case "sessions.reset":
+				write(map[string]interface{}{"type": "res", "id": req.ID, "ok": true})
+
+			case "chat.abort":
+				// Aborting ends the run with an error, as the gateway does
+				var params struct {
+					RunID string `json:"runId"`
+				}
+				json.Unmarshal(req.Params, &params)
+				write(map[string]interface{}{"type": "res", "id": req.ID, "ok": true})
+				write(map[string]interface{}{
+					"type":  "event",
+					"event": "agent",
+					"payload": map[string]interface{}{
+						"runId":  params.RunID,
+						"stream": "lifecycle",
+						"data":   map[string]string{"phase": "error", "message": "aborted"},
+					},
+				})
+
+			case "agent":
+				var params AgentParams
+				json.Unmarshal(req.Params, &params)
+				runID := "run-" + params.IdempotencyKey
+
+				event := func(stream string, data interface{}) {
+					write(map[string]interface{}{
+						"type":  "event",
+						"event": "agent",
+						"payload": map[string]interface{}{
+							"runId":  runID,
+							"stream": stream,
+							"data":   data,
+						},
+					})
+				}
+
+				// "blip" drops the connection while the run goes on; the
+				// run finishes on the next connection
+				if params.Message == "blip" {
+					write(map[string]interface{}{
+						"type":    "res",
+						"id":      req.ID,
+						"ok":      true,
+						"payload": map[string]string{"runId": runID},
+					})
+					g.mu.Lock()
+					g.resumed = append(g.resumed, runID)
+					g.mu.Unlock()
+					return
+				}
+
+				// "hang" starts a run that never ends
+				if params.Message == "hang" {
+					write(map[string]interface{}{
+						"type":    "res",
+						"id":      req.ID,
+						"ok":      true,
+						"payload": map[string]string{"runId": runID},
+					})
+					continue
+				}
+
+				// The first delta races ahead of the response on purpose
+				go func() {
+					event("assistant", map[string]string{"delta": "echo: "})
+					write(map[string]interface{}{
+						"type":    "res",
+						"id":      req.ID,
+						"ok":      true,
+						"payload": map[string]string{"runId": runID},
+					})
+					event("assistant", map[string]string{"delta": params.Message})
+					event("lifecycle", map[string]string{"phase": "end"})
+				}()
+			}
+		}
+	}))
+	t.Cleanup(g.server.Close)
+
+	return g
+}
+
+func (g *fakeGateway) port(t *testing.T) int {
+	t.Helper()
+
+	u, err := url.Parse(g.server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return port
+}
+
+func TestAskClawdbotMultiplexesRuns(t *testing.T) {
+	gateway := newFakeGateway(t)
+	client := NewClient(gateway.port(t), "token", "main")
+	defer client.Close()
+
+	messages := []string{"one", "two", "three", "four"}
+	replies := make([]string, len(messages))
+	errs := make([]error, len(messages))
+
+	var wg sync.WaitGroup
+	for i, msg := range messages {
+		wg.Add(1)
+		go func(i int, msg string) {
+			defer wg.Done()
+			replies[i], errs[i] = client.AskClawdbot(context.Background(), msg, "session-"+msg, nil)
+		}(i, msg)
+	}
+	wg.Wait()
+
+	for i, msg := range messages {
+		if errs[i] != nil {
+			t.Fatalf("AskClawdbot(%q) error: %v", msg, errs[i])
+		}
+		if want := "echo: " + msg; replies[i] != want {
+			t.Fatalf("AskClawdbot(%q) = %q, want %q", msg, replies[i], want)
+		}
+	}
+
+	if err := client.ResetSession(context.Background(), "session-one"); err != nil {
+		t.Fatalf("ResetSession() error: %v", err)
+	}
+
+	if n := atomic.LoadInt32(&gateway.connections); n != 1 {
+		t.Fatalf("gateway saw %d connections, want 1", n)
+	}
+}
+
+func TestAskClawdbotReportsProgressInOrder(t *testing.T) {
+	gateway := newFakeGateway(t)
+	client := NewClient(gateway.port(t), "token", "main")
+	defer client.Close()
+
+	var deltas []string
+	_, err := client.AskClawdbot(context.Background(), "hello", "session", func(stream, data string) {
+		var streamData StreamData
+		json.Unmarshal([]byte(data), &streamData)
+		deltas = append(deltas, streamData.Delta)
+	})
+	if err != nil {
+		t.Fatalf("AskClawdbot() error: %v", err)
+	}
+
+	if len(deltas) != 2 || deltas[0] != "echo: " || deltas[1] != "hello" {
+		t.Fatalf("progress deltas = %q, want [\"echo: \" \"hello\"]", deltas)
+	}
+}
+
+func TestAskStopsWhenContextIsCancelled(t *testing.T) {
+	gateway := newFakeGateway(t)
+	client := NewClient(gateway.port(t), "token", "main")
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	_, err := client.AskClawdbot(ctx, "hang", "session", nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("AskClawdbot() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestAbortRunEndsTheRun(t *testing.T) {
+	gateway := newFakeGateway(t)
+	client := NewClient(gateway.port(t), "token", "main")
+	defer client.Close()
+
+	started := make(chan string, 1)
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Ask(context.Background(), AskRequest{
+			Text:       "hang",
+			SessionKey: "session",
+			OnStart:    func(runID string) { started <- runID },
+		}, nil)
+		done <- err
+	}()
+
+	runID := <-started
+	if !strings.HasPrefix(runID, "run-") {
+		t.Fatalf("OnStart got %q, want the run ID from the gateway", runID)
+	}
+	if err := client.AbortRun(context.Background(), "session", runID); err != nil {
+		t.Fatalf("AbortRun() error: %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if err == nil || err.Error() != "aborted" {
+			t.Fatalf("Ask() error = %v, want aborted", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Ask() did not return after AbortRun")
+	}
+}
+
+func TestAskTimeout(t *testing.T) {
+	gateway := newFakeGateway(t)
+	client := NewClient(gateway.port(t), "token", "main")
+	defer client.Close()
+
+	_, err := client.Ask(context.Background(), AskRequest{
+		Text:       "hang",
+		SessionKey: "session",
+		Timeout:    50 * time.Millisecond,
+	}, nil)
+	if err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Fatalf("Ask() error = %v, want a timeout", err)
+	}
+}
+
+func TestRunSurvivesReconnect(t *testing.T) {
+	gateway := newFakeGateway(t)
+	client := NewClient(gateway.port(t), "token", "main")
+	defer client.Close()
+
+	reply, err := client.Ask(context.Background(), AskRequest{Text: "blip", SessionKey: "session"}, nil)
+	if err != nil || reply != "resumed" {
+		t.Fatalf("Ask() = %q, %v; want the reply from the next connection", reply, err)
+	}
+	if n := atomic.LoadInt32(&gateway.connections); n != 2 {
+		t.Fatalf("connections = %d, want 2", n)
+	}
+}
+
+func TestRunRegistrationRacesEvents(t *testing.T) {
+	client := NewClient(0, "token", "main")
+
+	const events = 200
+	var got []string
+	r := newRun(func(stream, data string) {
+		var d StreamData
+		json.Unmarshal([]byte(data), &d)
+		got = append(got, d.Delta)
+	})
+	client.registerRun("idempotency-key", r)
+
+	// The reader delivers events for the run while Ask registers its ID
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < events; i++ {
+			client.dispatchEvent(EventPayload{
+				RunID:  "run-1",
+				Stream: "assistant",
+				Data:   json.RawMessage(`{"delta":"` + strconv.Itoa(i) + `"}`),
+			})
+		}
+	}()
+	time.Sleep(time.Millisecond)
+	client.registerRun("run-1", r)
+	<-done
+
+	if len(got) != events {
+		t.Fatalf("run got %d events, want %d", len(got), events)
+	}
+	for i, delta := range got {
+		if delta != strconv.Itoa(i) {
+			t.Fatalf("event %d = %s, want the events in order", i, delta)
+		}
+	}
+}
@@ -0,0 +1,443 @@
+package clawdbot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	handshakeTimeout = 10 * time.Second
+	connectWait      = 15 * time.Second
+	pingInterval     = 30 * time.Second
+	pongWait         = 90 * time.Second
+	minBackoff       = 500 * time.Millisecond
+	maxBackoff       = 30 * time.Second
+
+	// orphanTTL bounds how long events for an unknown run are kept in case
+	// the run is registered shortly after
+	orphanTTL       = 10 * time.Second
+	maxOrphanEvents = 256
+
+	// runReconnectWait is how long runs outlive a lost connection. The
+	// gateway keeps running them, so their events resume on the next
+	// connection.
+	runReconnectWait = 30 * time.Second
+)
+
+var (
+	errClosed   = errors.New("gateway client closed")
+	errConnLost = errors.New("gateway connection lost")
+)
+
+// result is the outcome of a pending request
+type result struct {
+	resp *Response
+	err  error
+}
+
+// orphanEvents buffers agent events that arrived before their run was known
+type orphanEvents struct {
+	events []EventPayload
+	seen   time.Time
+}
+
+// Start connects to the gateway in the background and keeps the connection
+// alive, reconnecting with backoff. Requests call it implicitly.
+func (c *Client) Start() {
+	c.startOnce.Do(func() {
+		go c.loop()
+	})
+}
+
+// Close shuts down the gateway connection and fails outstanding requests
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+
+		c.mu.Lock()
+		conn := c.conn
+		c.mu.Unlock()
+		if conn != nil {
+			conn.Close()
+		}
+	})
+	return nil
+}
+
+// Connected reports whether the gateway connection is currently up
+func (c *Client) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn != nil
+}
+
+// loop maintains the gateway connection until Close is called
+func (c *Client) loop() {
+	backoff := minBackoff
+
+	for {
+		conn, err := c.dial()
+		if err != nil {
+			c.mu.Lock()
+			c.lastErr = err
+			c.mu.Unlock()
+
+			log.Printf("[Clawdbot] Gateway connect failed, retrying in %v: %v", backoff, err)
+			select {
+			case <-c.closed:
+				return
+			case <-time.After(backoff):
+			}
+
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+
+		backoff = minBackoff
+		log.Printf("[Clawdbot] Connected to gateway on port %d", c.port)
+
+		c.mu.Lock()
+		c.conn = conn
+		c.lastErr = nil
+		close(c.ready)
+		c.mu.Unlock()
+
+		err = c.readLoop(conn)
+		c.dropConn(conn, err)
+
+		select {
+		case <-c.closed:
+			return
+		default:
+		}
+		log.Printf("[Clawdbot] Gateway connection lost: %v", err)
+	}
+}
+
+// dial opens a connection and completes the connect.challenge handshake
+func (c *Client) dial() (*websocket.Conn, error) {
+	url := fmt.Sprintf("ws://127.0.0.1:%d", c.port)
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to gateway: %w", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	connectID := "connect-" + uuid.New().String()
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("gateway handshake failed: %w", err)
+		}
+
+		var resp Response
+		if err := json.Unmarshal(message, &resp); err != nil {
+			continue
+		}
+
+		// Step 1: Handle connect challenge
+		if resp.Type == "event" && resp.Event == "connect.challenge" {
+			if err := conn.WriteJSON(c.connectRequest(connectID)); err != nil {
+				conn.Close()
+				return nil, fmt.Errorf("failed to send connect request: %w", err)
+			}
+			continue
+		}
+
+		// Step 2: Handle connect response
+		if resp.Type == "res" && resp.ID == connectID {
+			if !resp.OK {
+				conn.Close()
+				return nil, responseError(&resp, "connect failed")
+			}
+			break
+		}
+	}
+
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	return conn, nil
+}
+
+func (c *Client) connectRequest(id string) Request {
+	return Request{
+		Type:   "req",
+		ID:     id,
+		Method: "connect",
+		Params: ConnectParams{
+			MinProtocol: 3,
+			MaxProtocol: 3,
+			Client: ClientInfo{
+				ID:       "gateway-client",
+				Version:  "0.2.0",
+				Platform: "linux",
+				Mode:     "backend",
+			},
+			Role:   "operator",
+			Scopes: []string{"operator.read", "operator.write", "operator.admin"},
+			Auth: AuthInfo{
+				Token: c.token,
+			},
+			Locale:    "zh-CN",
+			UserAgent: "clawdbot-bridge-go",
+		},
+	}
+}
+
+// readLoop routes frames until the connection fails
+func (c *Client) readLoop(conn *websocket.Conn) error {
+	stopPing := make(chan struct{})
+	defer close(stopPing)
+	go c.pingLoop(conn, stopPing)
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+
+		var resp Response
+		if err := json.Unmarshal(message, &resp); err != nil {
+			continue
+		}
+
+		switch {
+		case resp.Type == "res":
+			c.mu.Lock()
+			ch, ok := c.pending[resp.ID]
+			delete(c.pending, resp.ID)
+			c.mu.Unlock()
+			if ok {
+				ch <- result{resp: &resp}
+			}
+
+		case resp.Type == "event" && resp.Event == "agent":
+			var event EventPayload
+			if err := json.Unmarshal(resp.Payload, &event); err != nil {
+				continue
+			}
+			c.dispatchEvent(event)
+		}
+	}
+}
+
+func (c *Client) pingLoop(conn *websocket.Conn, stop <-chan struct{}) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			c.writeMu.Lock()
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(handshakeTimeout))
+			c.writeMu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// dispatchEvent hands an agent event to its run, or parks it briefly. The
+// run handles it under c.mu, like the events registerRun replays.
+func (c *Client) dispatchEvent(event EventPayload) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if r, ok := c.runs[event.RunID]; ok {
+		r.handle(event)
+		return
+	}
+	c.parkOrphan(event)
+}
+
+// parkOrphan must be called with c.mu held
+func (c *Client) parkOrphan(event EventPayload) {
+	if event.RunID == "" {
+		return
+	}
+
+	now := time.Now()
+	for id, o := range c.orphans {
+		if now.Sub(o.seen) > orphanTTL {
+			delete(c.orphans, id)
+		}
+	}
+
+	o, ok := c.orphans[event.RunID]
+	if !ok {
+		o = &orphanEvents{}
+		c.orphans[event.RunID] = o
+	}
+	o.seen = now
+	if len(o.events) < maxOrphanEvents {
+		o.events = append(o.events, event)
+	}
+}
+
+// dropConn fails the requests that were waiting on a broken connection.
+// Runs wait up to runReconnectWait for the next connection and fail if it
+// does not come up in time.
+func (c *Client) dropConn(conn *websocket.Conn, err error) {
+	conn.Close()
+
+	c.mu.Lock()
+	c.conn = nil
+	c.lastErr = err
+	c.ready = make(chan struct{})
+	ready := c.ready
+	pending := c.pending
+	c.pending = make(map[string]chan result)
+	runs := make([]*run, 0, len(c.runs))
+	for _, r := range c.runs {
+		runs = append(runs, r)
+	}
+	c.orphans = make(map[string]*orphanEvents)
+	c.mu.Unlock()
+
+	for _, ch := range pending {
+		ch <- result{err: errConnLost}
+	}
+	if len(runs) == 0 {
+		return
+	}
+
+	go func() {
+		timer := time.NewTimer(runReconnectWait)
+		defer timer.Stop()
+
+		select {
+		case <-ready:
+			return
+		case <-c.closed:
+		case <-timer.C:
+		}
+		for _, r := range runs {
+			r.finish("", errConnLost)
+		}
+	}()
+}
+
+// waitConn returns the live connection, waiting for a reconnect if needed
+func (c *Client) waitConn(ctx context.Context) (*websocket.Conn, error) {
+	c.Start()
+
+	timer := time.NewTimer(connectWait)
+	defer timer.Stop()
+
+	for {
+		c.mu.Lock()
+		conn, ready, lastErr := c.conn, c.ready, c.lastErr
+		c.mu.Unlock()
+
+		if conn != nil {
+			return conn, nil
+		}
+
+		select {
+		case <-ready:
+		case <-c.closed:
+			return nil, errClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+			if lastErr != nil {
+				return nil, fmt.Errorf("gateway not connected: %w", lastErr)
+			}
+			return nil, fmt.Errorf("gateway not connected")
+		}
+	}
+}
+
+// call sends a request and waits for its response
+func (c *Client) call(ctx context.Context, method string, params interface{}, timeout time.Duration) (*Response, error) {
+	conn, err := c.waitConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	id := uuid.New().String()
+	ch := make(chan result, 1)
+
+	c.mu.Lock()
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	c.writeMu.Lock()
+	err = conn.WriteJSON(Request{
+		Type:   "req",
+		ID:     id,
+		Method: method,
+		Params: params,
+	})
+	c.writeMu.Unlock()
+	if err != nil {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return nil, fmt.Errorf("failed to send %s request: %w", method, err)
+	}
+
+	select {
+	case res := <-ch:
+		return res.resp, res.err
+	case <-c.closed:
+		return nil, errClosed
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return nil, ctx.Err()
+	case <-time.After(timeout):
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return nil, fmt.Errorf("timeout waiting for %s response", method)
+	}
+}
+
+// registerRun routes events for runID to r and replays any that arrived early.
+// The replay happens under c.mu, which dispatchEvent also holds, so the
+// reader cannot deliver newer events first or at the same time.
+func (c *Client) registerRun(runID string, r *run) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.runs[runID] = r
+	if o, ok := c.orphans[runID]; ok {
+		delete(c.orphans, runID)
+		for _, event := range o.events {
+			r.handle(event)
+		}
+	}
+}
+
+// unregisterRun removes every route to r
+func (c *Client) unregisterRun(r *run) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, registered := range c.runs {
+		if registered == r {
+			delete(c.runs, id)
+		}
+	}
+}
@@ -9,22 +9,107 @@ import (
 
 // Config holds all configuration for the bridge
 type Config struct {
-	Feishu   FeishuConfig
+	// Feishu, DingTalk and WeCom are nil when the adapter is not configured
+	Feishu   *FeishuConfig
+	DingTalk *DingTalkConfig
+	WeCom    *WeComConfig
+	Bridge   BridgeConfig
 	Clawdbot ClawdbotConfig
 }
 
 // FeishuConfig contains Feishu-specific configuration
 type FeishuConfig struct {
-	AppID               string
-	AppSecret           string
+	AppID     string
+	AppSecret string
+	// Mode is "websocket" (default) or "http"
+	Mode              string
+	Listen            string
+	Path              string
+	VerificationToken string
+	EncryptKey        string
+	// ReplyFormat is "card" (default) or "text"
+	ReplyFormat string
+}
+
+// DingTalkConfig contains DingTalk-specific configuration
+type DingTalkConfig struct {
+	ClientID     string
+	ClientSecret string
+	RobotCode    string
+	// APIBase overrides https://api.dingtalk.com, e.g. for a local fake
+	APIBase string
+}
+
+// WeComConfig contains WeCom (企业微信) application configuration
+type WeComConfig struct {
+	CorpID         string
+	CorpSecret     string
+	AgentID        int
+	Token          string
+	EncodingAESKey string
+	Listen         string
+	Path           string
+	APIBase        string
+}
+
+// DefaultDrainTimeoutMs is the drain timeout when bridge.json sets none
+const DefaultDrainTimeoutMs = 30000
+
+// BridgeConfig contains platform-independent reply behaviour
+type BridgeConfig struct {
 	ThinkingThresholdMs int
+	StreamIntervalMs    int
+	// DrainTimeoutMs is how long shutdown waits for running agent requests
+	DrainTimeoutMs int
+	// MaxImageBytes and ImageTypes limit images forwarded to the agent
+	MaxImageBytes int64
+	ImageTypes    []string
+	// MaxFileBytes limits file messages staged for the agent
+	MaxFileBytes int64
+	// CodeFileBytes sends longer reply code blocks as files, 0 disables it
+	CodeFileBytes int
+	// ReplyMode is the default of "message", "reply" or "thread"
+	ReplyMode string
+	// SessionStrategy is "chat", "sender", "thread" or a key template
+	SessionStrategy string
+	// GroupHeader and P2PHeader prefix messages sent to the agent; empty,
+	// the default, sends messages unchanged
+	GroupHeader string
+	P2PHeader   string
+	// TriggerMode is the default group trigger mode, empty uses "heuristic"
+	TriggerMode string
+	// Trigger lists for the heuristic mode; nil keeps the built-in list
+	BotNames    []string
+	Keywords    []string
+	Patterns    []string
+	ActionVerbs []string
+	// Admins are the user IDs allowed to change administrative chat
+	// settings; empty allows nobody
+	Admins []string
+	// SenderLimit and ChatLimit limit agent requests per sender and chat
+	SenderLimit RateLimit
+	ChatLimit   RateLimit
+	// Progress is the default tool call progress mode, empty uses "summary"
+	Progress string
+}
+
+// RateLimit is a token bucket and a daily quota; zero values disable them
+type RateLimit struct {
+	PerMinute float64
+	Burst     int
+	Daily     int
 }
 
 // ClawdbotConfig contains Clawdbot Gateway configuration
 type ClawdbotConfig struct {
-	GatewayPort  int
-	GatewayToken string
-	AgentID      string
+	GatewayPort       int
+	GatewayToken      string
+	AgentID           string
+	MaxConcurrentRuns int
+	// RunTimeoutMs bounds the wait for an agent reply, 0 uses the client
+	// default of 15 minutes; AgentRunTimeoutMs overrides it per agent
+	RunTimeoutMs      int
+	AgentRunTimeoutMs map[string]int
 }
 
 // clawdbotJSON matches ~/.clawdbot/clawdbot.json (managed by ClawdBot)
@@ -39,12 +124,130 @@ type clawdbotJSON struct {
 
 // bridgeJSON matches ~/.clawdbot/bridge.json
 type bridgeJSON struct {
-	Feishu struct {
-		AppID     string `json:"app_id"`
-		AppSecret string `json:"app_secret"`
-	} `json:"feishu"`
-	ThinkingThresholdMs *int   `json:"thinking_threshold_ms,omitempty"`
-	AgentID             string `json:"agent_id"`
+	Feishu              *feishuJSON      `json:"feishu,omitempty"`
+	DingTalk            *dingtalkJSON    `json:"dingtalk,omitempty"`
+	WeCom               *wecomJSON       `json:"wecom,omitempty"`
+	ThinkingThresholdMs *int             `json:"thinking_threshold_ms,omitempty"`
+	StreamIntervalMs    *int             `json:"stream_interval_ms,omitempty"`
+	DrainTimeoutMs      *int             `json:"drain_timeout_ms,omitempty"`
+	MaxConcurrentRuns   *int             `json:"max_concurrent_runs,omitempty"`
+	RunTimeoutMs        *int             `json:"run_timeout_ms,omitempty"`
+	AgentRunTimeoutMs   map[string]int   `json:"agent_run_timeout_ms,omitempty"`
+	AgentID             string           `json:"agent_id"`
+	Attachments         *attachmentsJSON `json:"attachments,omitempty"`
+	CodeFileBytes       *int             `json:"code_file_bytes,omitempty"`
+	ReplyMode           string           `json:"reply_mode,omitempty"`
+	SessionStrategy     string           `json:"session_strategy,omitempty"`
+	Progress            string           `json:"progress,omitempty"`
+	MessageHeader       *headerJSON      `json:"message_header,omitempty"`
+	Triggers            *triggersJSON    `json:"triggers,omitempty"`
+	Admins              []string         `json:"admins,omitempty"`
+	RateLimits          *rateLimitsJSON  `json:"rate_limits,omitempty"`
+}
+
+// rateLimitsJSON is the rate_limits block in bridge.json
+type rateLimitsJSON struct {
+	Sender *rateLimitJSON `json:"sender,omitempty"`
+	Chat   *rateLimitJSON `json:"chat,omitempty"`
+}
+
+type rateLimitJSON struct {
+	PerMinute float64 `json:"per_minute,omitempty"`
+	Burst     int     `json:"burst,omitempty"`
+	Daily     int     `json:"daily,omitempty"`
+}
+
+// rateLimit validates one limit of the rate_limits block
+func (r *rateLimitJSON) rateLimit(name string) (RateLimit, error) {
+	if r == nil {
+		return RateLimit{}, nil
+	}
+	if r.PerMinute < 0 || r.Burst < 0 || r.Daily < 0 {
+		return RateLimit{}, fmt.Errorf("rate_limits.%s values must not be negative in ~/.clawdbot/bridge.json", name)
+	}
+	return RateLimit{PerMinute: r.PerMinute, Burst: r.Burst, Daily: r.Daily}, nil
+}
+
+// triggersJSON is the triggers block in bridge.json
+type triggersJSON struct {
+	Mode        string   `json:"mode,omitempty"`
+	BotNames    []string `json:"bot_names,omitempty"`
+	Keywords    []string `json:"keywords,omitempty"`
+	Patterns    []string `json:"patterns,omitempty"`
+	ActionVerbs []string `json:"action_verbs,omitempty"`
+}
+
+// headerJSON is the message_header block in bridge.json. An empty string
+// turns the header off for that chat type.
+type headerJSON struct {
+	Group *string `json:"group,omitempty"`
+	P2P   *string `json:"p2p,omitempty"`
+}
+
+// attachmentsJSON is the attachments block in bridge.json
+type attachmentsJSON struct {
+	MaxImageMB *int     `json:"max_image_mb,omitempty"`
+	ImageTypes []string `json:"image_types,omitempty"`
+	MaxFileMB  *int     `json:"max_file_mb,omitempty"`
+}
+
+// feishuJSON is the feishu block in bridge.json
+type feishuJSON struct {
+	Enabled           *bool  `json:"enabled,omitempty"`
+	AppID             string `json:"app_id"`
+	AppSecret         string `json:"app_secret"`
+	Mode              string `json:"mode,omitempty"`
+	Listen            string `json:"listen,omitempty"`
+	Path              string `json:"path,omitempty"`
+	VerificationToken string `json:"verification_token,omitempty"`
+	EncryptKey        string `json:"encrypt_key,omitempty"`
+	ReplyFormat       string `json:"reply_format,omitempty"`
+}
+
+// configured reports whether the block should start an adapter
+func (p *feishuJSON) configured() bool {
+	if p == nil || (p.Enabled != nil && !*p.Enabled) {
+		return false
+	}
+	return p.AppID != "" || p.AppSecret != ""
+}
+
+// dingtalkJSON is the dingtalk block in bridge.json
+type dingtalkJSON struct {
+	Enabled      *bool  `json:"enabled,omitempty"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RobotCode    string `json:"robot_code,omitempty"`
+	APIBase      string `json:"api_base,omitempty"`
+}
+
+// configured reports whether the block should start an adapter
+func (d *dingtalkJSON) configured() bool {
+	if d == nil || (d.Enabled != nil && !*d.Enabled) {
+		return false
+	}
+	return d.ClientID != "" || d.ClientSecret != ""
+}
+
+// wecomJSON is the wecom block in bridge.json
+type wecomJSON struct {
+	Enabled        *bool  `json:"enabled,omitempty"`
+	CorpID         string `json:"corp_id"`
+	CorpSecret     string `json:"corp_secret"`
+	AgentID        int    `json:"agent_id"`
+	Token          string `json:"token"`
+	EncodingAESKey string `json:"encoding_aes_key"`
+	Listen         string `json:"listen,omitempty"`
+	Path           string `json:"path,omitempty"`
+	APIBase        string `json:"api_base,omitempty"`
+}
+
+// configured reports whether the block should start an adapter
+func (w *wecomJSON) configured() bool {
+	if w == nil || (w.Enabled != nil && !*w.Enabled) {
+		return false
+	}
+	return w.CorpID != "" || w.CorpSecret != ""
 }
 
 // Dir returns the config directory path
@@ -124,34 +327,206 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("failed to parse %s: %w", brPath, err)
 	}
 
-	// Validate required fields
-	if brCfg.Feishu.AppID == "" {
-		return nil, fmt.Errorf("feishu.app_id is required in ~/.clawdbot/bridge.json")
-	}
-	if brCfg.Feishu.AppSecret == "" {
-		return nil, fmt.Errorf("feishu.app_secret is required in ~/.clawdbot/bridge.json")
-	}
-
 	// Build config with defaults
 	cfg := &Config{
-		Feishu: FeishuConfig{
-			AppID:               brCfg.Feishu.AppID,
-			AppSecret:           brCfg.Feishu.AppSecret,
+		Bridge: BridgeConfig{
 			ThinkingThresholdMs: 0,
+			StreamIntervalMs:    1000,
+			DrainTimeoutMs:      DefaultDrainTimeoutMs,
+			MaxImageBytes:       10 << 20,
+			ImageTypes:          []string{"image/png", "image/jpeg", "image/gif", "image/webp"},
+			MaxFileBytes:        50 << 20,
 		},
 		Clawdbot: ClawdbotConfig{
-			GatewayPort:  gwCfg.Gateway.Port,
-			GatewayToken: gwCfg.Gateway.Auth.Token,
-			AgentID:      "main",
+			GatewayPort:       gwCfg.Gateway.Port,
+			GatewayToken:      gwCfg.Gateway.Auth.Token,
+			AgentID:           "main",
+			MaxConcurrentRuns: 8,
 		},
 	}
 
+	// Validate platform credentials
+	if brCfg.Feishu.configured() {
+		if brCfg.Feishu.AppID == "" {
+			return nil, fmt.Errorf("feishu.app_id is required in ~/.clawdbot/bridge.json")
+		}
+		if brCfg.Feishu.AppSecret == "" {
+			return nil, fmt.Errorf("feishu.app_secret is required in ~/.clawdbot/bridge.json")
+		}
+		cfg.Feishu = &FeishuConfig{
+			AppID:             brCfg.Feishu.AppID,
+			AppSecret:         brCfg.Feishu.AppSecret,
+			Mode:              brCfg.Feishu.Mode,
+			Listen:            brCfg.Feishu.Listen,
+			Path:              brCfg.Feishu.Path,
+			VerificationToken: brCfg.Feishu.VerificationToken,
+			EncryptKey:        brCfg.Feishu.EncryptKey,
+			ReplyFormat:       brCfg.Feishu.ReplyFormat,
+		}
+		switch cfg.Feishu.ReplyFormat {
+		case "":
+			cfg.Feishu.ReplyFormat = "card"
+		case "card", "text":
+		default:
+			return nil, fmt.Errorf("feishu.reply_format must be \"card\" or \"text\" in ~/.clawdbot/bridge.json, got %q", cfg.Feishu.ReplyFormat)
+		}
+		switch cfg.Feishu.Mode {
+		case "":
+			cfg.Feishu.Mode = "websocket"
+		case "websocket":
+		case "http":
+			// Without the token anyone who finds the URL could post events
+			if cfg.Feishu.VerificationToken == "" {
+				return nil, fmt.Errorf("feishu.verification_token is required in ~/.clawdbot/bridge.json when feishu.mode is \"http\"")
+			}
+			if cfg.Feishu.Listen == "" {
+				cfg.Feishu.Listen = ":8091"
+			}
+		default:
+			return nil, fmt.Errorf("feishu.mode must be \"websocket\" or \"http\" in ~/.clawdbot/bridge.json, got %q", cfg.Feishu.Mode)
+		}
+	}
+	if brCfg.DingTalk.configured() {
+		if brCfg.DingTalk.ClientID == "" {
+			return nil, fmt.Errorf("dingtalk.client_id is required in ~/.clawdbot/bridge.json")
+		}
+		if brCfg.DingTalk.ClientSecret == "" {
+			return nil, fmt.Errorf("dingtalk.client_secret is required in ~/.clawdbot/bridge.json")
+		}
+		cfg.DingTalk = &DingTalkConfig{
+			ClientID:     brCfg.DingTalk.ClientID,
+			ClientSecret: brCfg.DingTalk.ClientSecret,
+			RobotCode:    brCfg.DingTalk.RobotCode,
+			APIBase:      brCfg.DingTalk.APIBase,
+		}
+	}
+	if brCfg.WeCom.configured() {
+		w := brCfg.WeCom
+		for _, field := range []struct{ key, value string }{
+			{"corp_id", w.CorpID},
+			{"corp_secret", w.CorpSecret},
+			{"token", w.Token},
+			{"encoding_aes_key", w.EncodingAESKey},
+		} {
+			if field.value == "" {
+				return nil, fmt.Errorf("wecom.%s is required in ~/.clawdbot/bridge.json", field.key)
+			}
+		}
+		if w.AgentID == 0 {
+			return nil, fmt.Errorf("wecom.agent_id is required in ~/.clawdbot/bridge.json")
+		}
+		cfg.WeCom = &WeComConfig{
+			CorpID:         w.CorpID,
+			CorpSecret:     w.CorpSecret,
+			AgentID:        w.AgentID,
+			Token:          w.Token,
+			EncodingAESKey: w.EncodingAESKey,
+			Listen:         w.Listen,
+			Path:           w.Path,
+			APIBase:        w.APIBase,
+		}
+		if cfg.WeCom.Listen == "" {
+			cfg.WeCom.Listen = ":8090"
+		}
+	}
+	if cfg.Feishu == nil && cfg.DingTalk == nil && cfg.WeCom == nil {
+		return nil, fmt.Errorf("no IM platform configured in ~/.clawdbot/bridge.json, add a \"feishu\", \"dingtalk\" or \"wecom\" block")
+	}
+
 	if brCfg.ThinkingThresholdMs != nil {
-		cfg.Feishu.ThinkingThresholdMs = *brCfg.ThinkingThresholdMs
+		cfg.Bridge.ThinkingThresholdMs = *brCfg.ThinkingThresholdMs
+	}
+	if brCfg.StreamIntervalMs != nil {
+		cfg.Bridge.StreamIntervalMs = *brCfg.StreamIntervalMs
+	}
+	if brCfg.DrainTimeoutMs != nil {
+		if *brCfg.DrainTimeoutMs < 0 {
+			return nil, fmt.Errorf("drain_timeout_ms must not be negative in ~/.clawdbot/bridge.json")
+		}
+		cfg.Bridge.DrainTimeoutMs = *brCfg.DrainTimeoutMs
+	}
+	if brCfg.MaxConcurrentRuns != nil {
+		cfg.Clawdbot.MaxConcurrentRuns = *brCfg.MaxConcurrentRuns
 	}
 	if brCfg.AgentID != "" {
 		cfg.Clawdbot.AgentID = brCfg.AgentID
 	}
+	if brCfg.RunTimeoutMs != nil {
+		if *brCfg.RunTimeoutMs < 0 {
+			return nil, fmt.Errorf("run_timeout_ms must not be negative in ~/.clawdbot/bridge.json")
+		}
+		cfg.Clawdbot.RunTimeoutMs = *brCfg.RunTimeoutMs
+	}
+	for agentID, ms := range brCfg.AgentRunTimeoutMs {
+		if ms <= 0 {
+			return nil, fmt.Errorf("agent_run_timeout_ms.%s must be positive in ~/.clawdbot/bridge.json", agentID)
+		}
+	}
+	cfg.Clawdbot.AgentRunTimeoutMs = brCfg.AgentRunTimeoutMs
+	switch brCfg.ReplyMode {
+	case "", "message", "reply", "thread":
+		cfg.Bridge.ReplyMode = brCfg.ReplyMode
+	default:
+		return nil, fmt.Errorf("reply_mode must be \"message\", \"reply\" or \"thread\" in ~/.clawdbot/bridge.json, got %q", brCfg.ReplyMode)
+	}
+	switch brCfg.Progress {
+	case "", "off", "summary", "detailed":
+		cfg.Bridge.Progress = brCfg.Progress
+	default:
+		return nil, fmt.Errorf("progress must be \"off\", \"summary\" or \"detailed\" in ~/.clawdbot/bridge.json, got %q", brCfg.Progress)
+	}
+	// Strategy names and templates are validated when the bridge is created
+	cfg.Bridge.SessionStrategy = brCfg.SessionStrategy
+	if h := brCfg.MessageHeader; h != nil {
+		if h.Group != nil {
+			cfg.Bridge.GroupHeader = *h.Group
+		}
+		if h.P2P != nil {
+			cfg.Bridge.P2PHeader = *h.P2P
+		}
+	}
+	if t := brCfg.Triggers; t != nil {
+		switch t.Mode {
+		case "", "mention_only", "heuristic", "always", "never":
+			cfg.Bridge.TriggerMode = t.Mode
+		default:
+			return nil, fmt.Errorf("triggers.mode must be \"mention_only\", \"heuristic\", \"always\" or \"never\" in ~/.clawdbot/bridge.json, got %q", t.Mode)
+		}
+		// Patterns are compiled when the bridge is created
+		cfg.Bridge.BotNames = t.BotNames
+		cfg.Bridge.Keywords = t.Keywords
+		cfg.Bridge.Patterns = t.Patterns
+		cfg.Bridge.ActionVerbs = t.ActionVerbs
+	}
+	cfg.Bridge.Admins = brCfg.Admins
+	if r := brCfg.RateLimits; r != nil {
+		if cfg.Bridge.SenderLimit, err = r.Sender.rateLimit("sender"); err != nil {
+			return nil, err
+		}
+		if cfg.Bridge.ChatLimit, err = r.Chat.rateLimit("chat"); err != nil {
+			return nil, err
+		}
+	}
+	if brCfg.CodeFileBytes != nil {
+		cfg.Bridge.CodeFileBytes = *brCfg.CodeFileBytes
+	}
+	if a := brCfg.Attachments; a != nil {
+		if a.MaxImageMB != nil {
+			if *a.MaxImageMB <= 0 {
+				return nil, fmt.Errorf("attachments.max_image_mb must be positive in ~/.clawdbot/bridge.json")
+			}
+			cfg.Bridge.MaxImageBytes = int64(*a.MaxImageMB) << 20
+		}
+		if len(a.ImageTypes) > 0 {
+			cfg.Bridge.ImageTypes = a.ImageTypes
+		}
+		if a.MaxFileMB != nil {
+			if *a.MaxFileMB <= 0 {
+				return nil, fmt.Errorf("attachments.max_file_mb must be positive in ~/.clawdbot/bridge.json")
+			}
+			cfg.Bridge.MaxFileBytes = int64(*a.MaxFileMB) << 20
+		}
+	}
 	if cfg.Clawdbot.GatewayPort == 0 {
 		cfg.Clawdbot.GatewayPort = 18789
 	}
@@ -0,0 +1,231 @@
+package dingtalk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+	"github.com/wy51ai/moltbotCNAPP/internal/tokencache"
+)
+
+// Platform is the adapter name used in session keys
+const Platform = "dingtalk"
+
+// DefaultAPIBase is the DingTalk open platform endpoint
+const DefaultAPIBase = "https://api.dingtalk.com"
+
+// conversationGroup is the DingTalk conversation type of group chats;
+// "1" is used for 1:1 chats
+const conversationGroup = "2"
+
+// Client is a DingTalk robot client that receives messages over Stream mode
+type Client struct {
+	clientID     string
+	clientSecret string
+	robotCode    string
+	apiBase      string
+	httpClient   *http.Client
+	handler      im.Handler
+
+	tokens *tokencache.Cache
+
+	// conversations remembers how to reach each chat we received a message from
+	convMu        sync.RWMutex
+	conversations map[string]conversation
+}
+
+// conversation holds what the robot send API needs to reply to a chat
+type conversation struct {
+	chatType string
+	staffID  string
+	// webhook replies to the conversation without a staff ID until it expires
+	webhook        string
+	webhookExpires time.Time
+}
+
+// NewClient creates a new DingTalk client. robotCode defaults to clientID,
+// which is what DingTalk assigns to robots of internal apps. apiBase
+// defaults to DefaultAPIBase.
+func NewClient(clientID, clientSecret, robotCode, apiBase string) *Client {
+	if robotCode == "" {
+		robotCode = clientID
+	}
+	if apiBase == "" {
+		apiBase = DefaultAPIBase
+	}
+
+	c := &Client{
+		clientID:      clientID,
+		clientSecret:  clientSecret,
+		robotCode:     robotCode,
+		apiBase:       strings.TrimRight(apiBase, "/"),
+		httpClient:    &http.Client{Timeout: 30 * time.Second},
+		conversations: make(map[string]conversation),
+	}
+	c.tokens = tokencache.New(c.fetchToken)
+	return c
+}
+
+// Platform returns the adapter name
+func (c *Client) Platform() string {
+	return Platform
+}
+
+// maxTextBytes keeps sampleText messages within what the robot API accepts
+const maxTextBytes = 5000
+
+// MaxMessageBytes is the longest reply that fits in one message
+func (c *Client) MaxMessageBytes() int {
+	return maxTextBytes
+}
+
+// SendMessage sends a text message to a chat we have received a message from
+func (c *Client) SendMessage(ctx context.Context, chatID, text string) (string, error) {
+	c.convMu.RLock()
+	conv, ok := c.conversations[chatID]
+	c.convMu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("failed to send message: unknown conversation %s", chatID)
+	}
+
+	msgParam, _ := json.Marshal(map[string]string{"content": text})
+
+	var path string
+	var body map[string]interface{}
+	if conv.chatType == conversationGroup {
+		path = "/v1.0/robot/groupMessages/send"
+		body = map[string]interface{}{
+			"robotCode":          c.robotCode,
+			"openConversationId": chatID,
+			"msgKey":             "sampleText",
+			"msgParam":           string(msgParam),
+		}
+	} else if conv.staffID == "" {
+		// External contacts and robots without the contact permission
+		// have no staff ID, so only the session webhook can reach them
+		return "", c.sendBySessionWebhook(ctx, conv, text)
+	} else {
+		path = "/v1.0/robot/oToMessages/batchSend"
+		body = map[string]interface{}{
+			"robotCode": c.robotCode,
+			"userIds":   []string{conv.staffID},
+			"msgKey":    "sampleText",
+			"msgParam":  string(msgParam),
+		}
+	}
+
+	var resp struct {
+		ProcessQueryKey string `json:"processQueryKey"`
+	}
+	if err := c.callAPI(ctx, path, body, &resp); err != nil {
+		return "", fmt.Errorf("failed to send message: %w", err)
+	}
+
+	return resp.ProcessQueryKey, nil
+}
+
+// sendBySessionWebhook replies through the webhook of the latest message
+// in a conversation. Webhook messages have no ID.
+func (c *Client) sendBySessionWebhook(ctx context.Context, conv conversation, text string) error {
+	if conv.webhook == "" || time.Now().After(conv.webhookExpires) {
+		return fmt.Errorf("failed to send message: the sender has no staff ID and the session webhook expired")
+	}
+
+	var resp struct {
+		ErrCode int    `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+	}
+	err := c.postURL(ctx, conv.webhook, "", map[string]interface{}{
+		"msgtype": "text",
+		"text":    map[string]string{"content": text},
+	}, &resp)
+	if err == nil && resp.ErrCode != 0 {
+		err = fmt.Errorf("%d %s", resp.ErrCode, resp.ErrMsg)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	return nil
+}
+
+// fetchToken gets a new app access token
+func (c *Client) fetchToken(ctx context.Context) (string, time.Duration, error) {
+	var resp struct {
+		AccessToken string `json:"accessToken"`
+		ExpireIn    int    `json:"expireIn"`
+	}
+	err := c.post(ctx, "/v1.0/oauth2/accessToken", "", map[string]string{
+		"appKey":    c.clientID,
+		"appSecret": c.clientSecret,
+	}, &resp)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to get access token: %w", err)
+	}
+	return resp.AccessToken, time.Duration(resp.ExpireIn) * time.Second, nil
+}
+
+// callAPI posts to an authenticated open platform API
+func (c *Client) callAPI(ctx context.Context, path string, body, out interface{}) error {
+	token, err := c.tokens.Get(ctx)
+	if err != nil {
+		return err
+	}
+	return c.post(ctx, path, token, body, out)
+}
+
+// post sends a JSON request to an open platform API and decodes the JSON
+// response
+func (c *Client) post(ctx context.Context, path, token string, body, out interface{}) error {
+	return c.postURL(ctx, c.apiBase+path, token, body, out)
+}
+
+// postURL sends a JSON request and decodes the JSON response
+func (c *Client) postURL(ctx context.Context, url, token string, body, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("x-acs-dingtalk-access-token", token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(respBody, &apiErr) == nil && apiErr.Message != "" {
+			return fmt.Errorf("%s: %s", apiErr.Code, apiErr.Message)
+		}
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
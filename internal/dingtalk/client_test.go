@@ -0,0 +1,237 @@
+package dingtalk
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+// fakeDingTalk serves the open platform APIs and the Stream gateway
+type fakeDingTalk struct {
+	server *httptest.Server
+	acks   chan ackFrame
+	sent   chan map[string]interface{}
+	frames []streamFrame
+}
+
+func newFakeDingTalk(t *testing.T, frames ...streamFrame) *fakeDingTalk {
+	t.Helper()
+
+	f := &fakeDingTalk{
+		acks:   make(chan ackFrame, 10),
+		sent:   make(chan map[string]interface{}, 10),
+		frames: frames,
+	}
+	upgrader := websocket.Upgrader{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1.0/gateway/connections/open", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"endpoint": "ws" + strings.TrimPrefix(f.server.URL, "http") + "/stream",
+			"ticket":   "ticket-1",
+		})
+	})
+	mux.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("ticket") != "ticket-1" {
+			http.Error(w, "bad ticket", http.StatusForbidden)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for _, frame := range f.frames {
+			conn.WriteJSON(frame)
+			var a ackFrame
+			if err := conn.ReadJSON(&a); err != nil {
+				return
+			}
+			f.acks <- a
+		}
+		// Keep the connection open until the client goes away
+		conn.ReadMessage()
+	})
+	mux.HandleFunc("/v1.0/oauth2/accessToken", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"accessToken": "token-1",
+			"expireIn":    7200,
+		})
+	})
+	sendHandler := func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-acs-dingtalk-access-token") != "token-1" {
+			http.Error(w, `{"code":"InvalidAuthentication","message":"bad token"}`, http.StatusUnauthorized)
+			return
+		}
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		body["path"] = r.URL.Path
+		f.sent <- body
+		json.NewEncoder(w).Encode(map[string]string{"processQueryKey": "query-1"})
+	}
+	mux.HandleFunc("/v1.0/robot/groupMessages/send", sendHandler)
+	mux.HandleFunc("/v1.0/robot/oToMessages/batchSend", sendHandler)
+	mux.HandleFunc("/robot/sendBySession", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		body["path"] = r.URL.Path
+		f.sent <- body
+		json.NewEncoder(w).Encode(map[string]interface{}{"errcode": 0, "errmsg": "ok"})
+	})
+
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+
+	return f
+}
+
+func callbackFrame(t *testing.T, messageID string, msg map[string]interface{}) streamFrame {
+	t.Helper()
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return streamFrame{
+		SpecVersion: "1.0",
+		Type:        "CALLBACK",
+		Headers: map[string]string{
+			"topic":     botMessageTopic,
+			"messageId": messageID,
+		},
+		Data: string(data),
+	}
+}
+
+func TestClientReceivesAndRepliesToGroupMessage(t *testing.T) {
+	fake := newFakeDingTalk(t,
+		streamFrame{Type: "SYSTEM", Headers: map[string]string{"topic": "ping", "messageId": "ping-1"}, Data: `{"opaque":"x"}`},
+		callbackFrame(t, "frame-1", map[string]interface{}{
+			"conversationId":   "cid-group",
+			"conversationType": "2",
+			"msgId":            "msg-1",
+			"msgtype":          "text",
+			"text":             map[string]string{"content": "  帮我看看日志 "},
+			"senderStaffId":    "staff-1",
+			"chatbotUserId":    "bot-1",
+			"isInAtList":       true,
+		}),
+	)
+
+	client := NewClient("app-key", "app-secret", "", fake.server.URL)
+	received := make(chan *im.Message, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Start(ctx, func(msg *im.Message) error {
+		received <- msg
+		return nil
+	})
+
+	for _, wantID := range []string{"ping-1", "frame-1"} {
+		select {
+		case a := <-fake.acks:
+			if a.Code != 200 || a.Headers["messageId"] != wantID {
+				t.Fatalf("ack = %+v, want code 200 for %s", a, wantID)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no ack for %s", wantID)
+		}
+	}
+
+	var msg *im.Message
+	select {
+	case msg = <-received:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler was not called")
+	}
+
+	if msg.Platform != Platform || msg.ChatID != "cid-group" || msg.ChatType != im.ChatTypeGroup || msg.Sender.ID != "staff-1" {
+		t.Fatalf("message = %+v, want dingtalk group message in cid-group", msg)
+	}
+	if msg.Content != "帮我看看日志" {
+		t.Fatalf("Content = %q, want trimmed text", msg.Content)
+	}
+	if len(msg.Mentions) != 1 || msg.Mentions[0].ID != "bot-1" || !msg.Mentions[0].Bot {
+		t.Fatalf("Mentions = %+v, want the robot mention", msg.Mentions)
+	}
+
+	msgID, err := client.SendMessage(ctx, "cid-group", "好的")
+	if err != nil {
+		t.Fatalf("SendMessage() error: %v", err)
+	}
+	if msgID != "query-1" {
+		t.Fatalf("SendMessage() = %q, want query-1", msgID)
+	}
+
+	body := <-fake.sent
+	if body["path"] != "/v1.0/robot/groupMessages/send" || body["openConversationId"] != "cid-group" || body["robotCode"] != "app-key" {
+		t.Fatalf("send request = %v, want group send to cid-group with robotCode app-key", body)
+	}
+	if body["msgParam"] != `{"content":"好的"}` {
+		t.Fatalf("msgParam = %v", body["msgParam"])
+	}
+}
+
+func TestReplyWithoutStaffIDUsesSessionWebhook(t *testing.T) {
+	fake := newFakeDingTalk(t)
+	p2p := func(id string, expires time.Time) streamFrame {
+		return callbackFrame(t, "frame-"+id, map[string]interface{}{
+			"conversationId":            "cid-" + id,
+			"conversationType":          "1",
+			"msgId":                     "msg-" + id,
+			"msgtype":                   "text",
+			"text":                      map[string]string{"content": "你好"},
+			"senderId":                  "$:LWCP_v1:$external",
+			"sessionWebhook":            fake.server.URL + "/robot/sendBySession?session=" + id,
+			"sessionWebhookExpiredTime": expires.UnixMilli(),
+		})
+	}
+	fake.frames = []streamFrame{p2p("live", time.Now().Add(time.Hour)), p2p("expired", time.Now().Add(-time.Minute))}
+
+	client := NewClient("app-key", "app-secret", "", fake.server.URL)
+	received := make(chan *im.Message, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Start(ctx, func(msg *im.Message) error {
+		received <- msg
+		return nil
+	})
+	for i := 0; i < 2; i++ {
+		select {
+		case <-received:
+		case <-time.After(2 * time.Second):
+			t.Fatal("handler was not called")
+		}
+	}
+
+	if _, err := client.SendMessage(ctx, "cid-live", "好的"); err != nil {
+		t.Fatalf("SendMessage() error: %v", err)
+	}
+	body := <-fake.sent
+	if body["path"] != "/robot/sendBySession" || body["msgtype"] != "text" {
+		t.Fatalf("send request = %v, want a text message to the session webhook", body)
+	}
+
+	if _, err := client.SendMessage(ctx, "cid-expired", "好的"); err == nil || !strings.Contains(err.Error(), "webhook expired") {
+		t.Fatalf("SendMessage() with an expired webhook error = %v, want it to say so", err)
+	}
+}
+
+func TestSendMessageToUnknownConversation(t *testing.T) {
+	client := NewClient("app-key", "app-secret", "", "http://127.0.0.1:1")
+
+	if _, err := client.SendMessage(context.Background(), "cid-unknown", "hi"); err == nil {
+		t.Fatal("SendMessage() to unknown conversation succeeded, want error")
+	}
+}
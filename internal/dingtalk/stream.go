@@ -0,0 +1,255 @@
+package dingtalk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+const (
+	// botMessageTopic delivers messages sent to the robot
+	botMessageTopic = "/v1.0/im/bot/messages/get"
+
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// streamFrame is a message pushed by the Stream gateway
+type streamFrame struct {
+	SpecVersion string            `json:"specVersion"`
+	Type        string            `json:"type"`
+	Headers     map[string]string `json:"headers"`
+	Data        string            `json:"data"`
+}
+
+// ackFrame acknowledges a stream frame so it is not redelivered
+type ackFrame struct {
+	Code    int               `json:"code"`
+	Headers map[string]string `json:"headers"`
+	Message string            `json:"message"`
+	Data    string            `json:"data"`
+}
+
+// botMessage is the payload of a robot message callback
+type botMessage struct {
+	ConversationID    string `json:"conversationId"`
+	ConversationType  string `json:"conversationType"`
+	ConversationTitle string `json:"conversationTitle"`
+	MsgID             string `json:"msgId"`
+	MsgType           string `json:"msgtype"`
+	Text              struct {
+		Content string `json:"content"`
+	} `json:"text"`
+	SenderID      string `json:"senderId"`
+	SenderStaffID string `json:"senderStaffId"`
+	SenderNick    string `json:"senderNick"`
+	ChatbotUserID string `json:"chatbotUserId"`
+	IsInAtList    bool   `json:"isInAtList"`
+	// SessionWebhook replies to the conversation until
+	// SessionWebhookExpiredTime, in Unix milliseconds
+	SessionWebhook            string `json:"sessionWebhook"`
+	SessionWebhookExpiredTime int64  `json:"sessionWebhookExpiredTime"`
+	AtUsers                   []struct {
+		DingtalkID string `json:"dingtalkId"`
+		StaffID    string `json:"staffId"`
+	} `json:"atUsers"`
+}
+
+// Start connects to the Stream gateway and passes received messages to
+// handler. It reconnects with backoff until ctx is done.
+func (c *Client) Start(ctx context.Context, handler im.Handler) error {
+	c.handler = handler
+	backoff := minBackoff
+
+	log.Printf("[DingTalk] Starting Stream client (clientId=%s)", c.clientID)
+
+	for {
+		err := c.runStream(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err == nil {
+			// The gateway asked us to reconnect
+			backoff = minBackoff
+			continue
+		}
+
+		log.Printf("[DingTalk] Stream connection failed, retrying in %v: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// runStream opens one Stream connection and serves it until it ends
+func (c *Client) runStream(ctx context.Context) error {
+	var open struct {
+		Endpoint string `json:"endpoint"`
+		Ticket   string `json:"ticket"`
+	}
+	err := c.post(ctx, "/v1.0/gateway/connections/open", "", map[string]interface{}{
+		"clientId":     c.clientID,
+		"clientSecret": c.clientSecret,
+		"subscriptions": []map[string]string{
+			{"type": "CALLBACK", "topic": botMessageTopic},
+		},
+		"ua": "clawdbot-bridge-go",
+	}, &open)
+	if err != nil {
+		return fmt.Errorf("failed to open stream connection: %w", err)
+	}
+
+	endpoint, err := url.Parse(open.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid stream endpoint %q: %w", open.Endpoint, err)
+	}
+	query := endpoint.Query()
+	query.Set("ticket", open.Ticket)
+	endpoint.RawQuery = query.Encode()
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, endpoint.String(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to connect to stream endpoint: %w", err)
+	}
+	defer conn.Close()
+
+	// Unblock ReadMessage when ctx is cancelled
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	log.Printf("[DingTalk] Connected to Stream gateway")
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		var frame streamFrame
+		if err := json.Unmarshal(message, &frame); err != nil {
+			log.Printf("[DingTalk] Failed to parse stream frame: %v", err)
+			continue
+		}
+
+		topic := frame.Headers["topic"]
+		switch {
+		case frame.Type == "SYSTEM" && topic == "ping":
+			if err := conn.WriteJSON(ack(frame, frame.Data)); err != nil {
+				return err
+			}
+
+		case frame.Type == "SYSTEM" && topic == "disconnect":
+			log.Printf("[DingTalk] Gateway requested reconnect")
+			return nil
+
+		case frame.Type == "CALLBACK" && topic == botMessageTopic:
+			// Ack first: DingTalk redelivers callbacks that are not acked quickly
+			if err := conn.WriteJSON(ack(frame, `{"response":null}`)); err != nil {
+				return err
+			}
+			c.handleBotMessage(frame.Data)
+		}
+	}
+}
+
+func ack(frame streamFrame, data string) ackFrame {
+	return ackFrame{
+		Code: 200,
+		Headers: map[string]string{
+			"contentType": "application/json",
+			"messageId":   frame.Headers["messageId"],
+		},
+		Message: "OK",
+		Data:    data,
+	}
+}
+
+// handleBotMessage converts a robot callback into an im.Message
+func (c *Client) handleBotMessage(data string) {
+	var msg botMessage
+	if err := json.Unmarshal([]byte(data), &msg); err != nil {
+		log.Printf("[DingTalk] Failed to parse bot message: %v", err)
+		return
+	}
+
+	// Only handle text messages
+	if msg.MsgType != "text" {
+		return
+	}
+
+	chatType := im.ChatTypeP2P
+	if msg.ConversationType == conversationGroup {
+		chatType = im.ChatTypeGroup
+	}
+
+	c.convMu.Lock()
+	c.conversations[msg.ConversationID] = conversation{
+		chatType:       msg.ConversationType,
+		staffID:        msg.SenderStaffID,
+		webhook:        msg.SessionWebhook,
+		webhookExpires: time.UnixMilli(msg.SessionWebhookExpiredTime),
+	}
+	c.convMu.Unlock()
+
+	message := &im.Message{
+		Platform:  Platform,
+		MessageID: msg.MsgID,
+		ChatID:    msg.ConversationID,
+		ChatType:  chatType,
+		ChatName:  msg.ConversationTitle,
+		Content:   strings.TrimSpace(msg.Text.Content),
+		// The staff ID is only set for members of the robot's organization
+		Sender: im.Sender{ID: msg.SenderStaffID, Name: msg.SenderNick},
+	}
+	if message.Sender.ID == "" {
+		message.Sender.ID = msg.SenderID
+	}
+
+	// DingTalk strips the robot's own @ from the text; keep it as a mention
+	// so group trigger rules see that the robot was addressed
+	botMentioned := false
+	for _, user := range msg.AtUsers {
+		isBot := user.DingtalkID == msg.ChatbotUserID
+		botMentioned = botMentioned || isBot
+		message.Mentions = append(message.Mentions, im.Mention{
+			ID:     user.DingtalkID,
+			UserID: user.StaffID,
+			Bot:    isBot,
+		})
+	}
+	if msg.IsInAtList && !botMentioned {
+		message.Mentions = append(message.Mentions, im.Mention{
+			ID:  msg.ChatbotUserID,
+			Bot: true,
+		})
+	}
+
+	if c.handler != nil {
+		if err := c.handler(message); err != nil {
+			log.Printf("[DingTalk] Failed to handle message: %v", err)
+		}
+	}
+}
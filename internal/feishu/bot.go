@@ -0,0 +1,70 @@
+package feishu
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
+)
+
+// botInfoRetry is how long to wait before asking for the bot info again
+// after a failed lookup
+const botInfoRetry = time.Minute
+
+// botIdentity caches the bot's own open_id, which tells mentions of the bot
+// apart from mentions of other members
+type botIdentity struct {
+	mu          sync.Mutex
+	openID      string
+	lastAttempt time.Time
+}
+
+// botOpenID returns the bot's open_id, fetching it from the bot info API
+// on first use. It returns "" while the lookup keeps failing.
+func (c *Client) botOpenID(ctx context.Context) string {
+	c.bot.mu.Lock()
+	defer c.bot.mu.Unlock()
+
+	if c.bot.openID != "" || time.Since(c.bot.lastAttempt) < botInfoRetry {
+		return c.bot.openID
+	}
+	c.bot.lastAttempt = time.Now()
+
+	openID, err := c.fetchBotOpenID(ctx)
+	if err != nil {
+		log.Printf("[Feishu] Failed to get bot info, treating every mention as a mention of the bot: %v", err)
+		return ""
+	}
+	c.bot.openID = openID
+	log.Printf("[Feishu] Bot open_id: %s", openID)
+	return openID
+}
+
+func (c *Client) fetchBotOpenID(ctx context.Context) (string, error) {
+	resp, err := c.client.Get(ctx, "/open-apis/bot/v3/info", nil, larkcore.AccessTokenTypeTenant)
+	if err != nil {
+		return "", fmt.Errorf("failed to get bot info: %w", err)
+	}
+
+	var info struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+		Bot  struct {
+			OpenID string `json:"open_id"`
+		} `json:"bot"`
+	}
+	if err := json.Unmarshal(resp.RawBody, &info); err != nil {
+		return "", fmt.Errorf("failed to parse bot info: %w", err)
+	}
+	if info.Code != 0 {
+		return "", fmt.Errorf("failed to get bot info: %s", info.Msg)
+	}
+	if info.Bot.OpenID == "" {
+		return "", fmt.Errorf("bot info has no open_id")
+	}
+	return info.Bot.OpenID, nil
+}
@@ -0,0 +1,57 @@
+package feishu
+
+import (
+	"context"
+	"log"
+
+	"github.com/larksuite/oapi-sdk-go/v3/event/dispatcher/callback"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+// SetButtons shows buttons under a sent card from its next update on.
+// Text messages cannot carry buttons, so they are left alone and false is
+// returned.
+func (c *Client) SetButtons(messageID string, buttons []im.Button) bool {
+	return c.cards.setButtons(messageID, buttons)
+}
+
+// handleCardAction turns a button press on a card into a message carrying
+// the button's command. Card callbacks must be enabled on the app's
+// "回调配置" page, with the same delivery mode as events.
+func (c *Client) handleCardAction(ctx context.Context, event *callback.CardActionTriggerEvent) (*callback.CardActionTriggerResponse, error) {
+	if c.opts.Mode == ModeHTTP && !c.validToken(event.EventV2Base) {
+		log.Printf("[Feishu] Dropped card action with invalid verification token")
+		return nil, nil
+	}
+
+	action := event.Event
+	if action == nil || action.Action == nil || action.Context == nil {
+		return nil, nil
+	}
+	command, _ := action.Action.Value["command"].(string)
+	if command == "" {
+		return nil, nil
+	}
+
+	message := &im.Message{
+		Platform:        Platform,
+		EventID:         eventID(event.EventV2Base),
+		ChatID:          action.Context.OpenChatID,
+		Content:         command,
+		ButtonMessageID: action.Context.OpenMessageID,
+	}
+	if operator := action.Operator; operator != nil {
+		message.Sender.ID = operator.OpenID
+		message.Sender.UserID = getStringValue(operator.UserID)
+		message.Sender.TenantKey = getStringValue(operator.TenantKey)
+		message.Sender.Type = "user"
+	}
+
+	if c.handler != nil {
+		if err := c.handler(message); err != nil {
+			return nil, err
+		}
+	}
+	return &callback.CardActionTriggerResponse{}, nil
+}
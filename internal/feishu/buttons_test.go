@@ -0,0 +1,70 @@
+package feishu
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/larksuite/oapi-sdk-go/v3/event/dispatcher/callback"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+func TestCardButtons(t *testing.T) {
+	content, err := renderCard("正在思考…", im.Button{Text: "停止", Command: "/stop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var card struct {
+		Elements []map[string]interface{} `json:"elements"`
+	}
+	if err := json.Unmarshal([]byte(content), &card); err != nil {
+		t.Fatal(err)
+	}
+	last := card.Elements[len(card.Elements)-1]
+	if last["tag"] != "action" || !strings.Contains(content, `"value":{"command":"/stop"}`) {
+		t.Fatalf("card = %s, want a stop button at the end", content)
+	}
+
+	c := NewClient("cli_test", "secret", Options{})
+	if c.SetButtons("om_text", []im.Button{{Text: "停止", Command: "/stop"}}) || c.cards.buttons("om_text") != nil {
+		t.Fatal("buttons set on a message that is not a card")
+	}
+	c.cards.add("om_card")
+	if !c.SetButtons("om_card", []im.Button{{Text: "停止", Command: "/stop"}}) || len(c.cards.buttons("om_card")) != 1 {
+		t.Fatal("buttons not kept for a card")
+	}
+}
+
+func TestCardActionBecomesMessage(t *testing.T) {
+	c := NewClient("cli_test", "secret", Options{})
+	var got *im.Message
+	c.handler = func(msg *im.Message) error {
+		got = msg
+		return nil
+	}
+
+	var event callback.CardActionTriggerEvent
+	err := json.Unmarshal([]byte(`{
+		"schema": "2.0",
+		"header": {"event_id": "ev_1", "event_type": "card.action.trigger"},
+		"event": {
+			"operator": {"open_id": "ou_a", "tenant_key": "tk-1"},
+			"action": {"tag": "button", "value": {"command": "/stop"}},
+			"context": {"open_message_id": "om_reply", "open_chat_id": "oc_1"}
+		}
+	}`), &event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.handleCardAction(context.Background(), &event); err != nil {
+		t.Fatalf("handleCardAction() error: %v", err)
+	}
+	if got == nil || got.Content != "/stop" || got.ButtonMessageID != "om_reply" ||
+		got.ChatID != "oc_1" || got.Sender.ID != "ou_a" || got.EventID != "ev_1" {
+		t.Fatalf("message = %+v", got)
+	}
+}
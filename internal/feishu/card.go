@@ -0,0 +1,252 @@
+package feishu
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+// cardRetention is how long sent cards are remembered for updates
+const cardRetention = 24 * time.Hour
+
+var (
+	headingPattern   = regexp.MustCompile(`^(#{1,6})\s+(.+?)\s*#*$`)
+	imageLinkPattern = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)[^)]*\)`)
+	tableRulePattern = regexp.MustCompile(`^\|?\s*:?-{3,}:?\s*(\|\s*:?-{3,}:?\s*)*\|?$`)
+)
+
+// cardElement is one element of an interactive card body
+type cardElement map[string]interface{}
+
+// renderCard converts agent Markdown into an interactive card. The card
+// markdown element handles emphasis, links, lists and code blocks; headings,
+// tables, rules and images are converted to what cards support.
+func renderCard(markdown string, buttons ...im.Button) (string, error) {
+	return marshalCard(append(markdownElements(markdown), actionElements(buttons)...))
+}
+
+// plainCard is a card that shows text verbatim. It replaces a rendered card
+// when Feishu rejects the rendered payload.
+func plainCard(text string, buttons ...im.Button) (string, error) {
+	return marshalCard(append([]cardElement{{
+		"tag":  "div",
+		"text": map[string]string{"tag": "plain_text", "content": text},
+	}}, actionElements(buttons)...))
+}
+
+// actionElements puts buttons in a row under the card body. The command
+// comes back in the value of the card.action.trigger callback.
+func actionElements(buttons []im.Button) []cardElement {
+	if len(buttons) == 0 {
+		return nil
+	}
+	actions := make([]map[string]interface{}, len(buttons))
+	for i, button := range buttons {
+		actions[i] = map[string]interface{}{
+			"tag":   "button",
+			"text":  map[string]string{"tag": "plain_text", "content": button.Text},
+			"type":  "default",
+			"value": map[string]string{"command": button.Command},
+		}
+	}
+	return []cardElement{{"tag": "action", "actions": actions}}
+}
+
+func marshalCard(elements []cardElement) (string, error) {
+	if len(elements) == 0 {
+		elements = []cardElement{markdownElement(" ")}
+	}
+	card := map[string]interface{}{
+		// update_multi is required to edit the card after it is sent
+		"config":   map[string]bool{"wide_screen_mode": true, "update_multi": true},
+		"elements": elements,
+	}
+	data, err := json.Marshal(card)
+	if err != nil {
+		return "", fmt.Errorf("failed to render card: %w", err)
+	}
+	return string(data), nil
+}
+
+// markdownElements splits Markdown into card elements
+func markdownElements(markdown string) []cardElement {
+	var elements []cardElement
+	var text []string
+
+	flushText := func() {
+		content := strings.Trim(strings.Join(text, "\n"), "\n")
+		if strings.TrimSpace(content) != "" {
+			elements = append(elements, markdownElement(content))
+		}
+		text = nil
+	}
+
+	lines := strings.Split(strings.ReplaceAll(markdown, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(trimmed, "```"):
+			// Code blocks stay in the markdown element, which renders them
+			// natively; an unterminated fence (while streaming) runs to the end
+			block := []string{line}
+			for i+1 < len(lines) {
+				i++
+				block = append(block, lines[i])
+				if strings.HasPrefix(strings.TrimSpace(lines[i]), "```") {
+					break
+				}
+			}
+			if !strings.HasPrefix(strings.TrimSpace(block[len(block)-1]), "```") || len(block) == 1 {
+				block = append(block, "```")
+			}
+			text = append(text, block...)
+
+		case strings.HasPrefix(trimmed, "|") && i+1 < len(lines) && tableRulePattern.MatchString(strings.TrimSpace(lines[i+1])):
+			header := splitTableRow(trimmed)
+			var rows [][]string
+			i++
+			for i+1 < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i+1]), "|") {
+				i++
+				rows = append(rows, splitTableRow(strings.TrimSpace(lines[i])))
+			}
+			flushText()
+			elements = append(elements, tableElement(header, rows))
+
+		case trimmed == "---" || trimmed == "***" || trimmed == "___":
+			flushText()
+			elements = append(elements, cardElement{"tag": "hr"})
+
+		case headingPattern.MatchString(trimmed):
+			// Card markdown has no headings, so render them in bold
+			m := headingPattern.FindStringSubmatch(trimmed)
+			text = append(text, "**"+m[2]+"**")
+
+		default:
+			// Images need an uploaded image key; show them as links instead
+			text = append(text, imageLinkPattern.ReplaceAllString(line, "[$1]($2)"))
+		}
+	}
+	flushText()
+
+	return elements
+}
+
+func markdownElement(content string) cardElement {
+	return cardElement{"tag": "markdown", "content": content}
+}
+
+// tableElement builds a card table component
+func tableElement(header []string, rows [][]string) cardElement {
+	columns := make([]map[string]string, len(header))
+	for i, name := range header {
+		columns[i] = map[string]string{
+			"name":         fmt.Sprintf("c%d", i),
+			"display_name": name,
+			"data_type":    "lark_md",
+			"width":        "auto",
+		}
+	}
+
+	data := make([]map[string]string, len(rows))
+	for r, row := range rows {
+		data[r] = make(map[string]string, len(header))
+		for i := range header {
+			cell := ""
+			if i < len(row) {
+				cell = row[i]
+			}
+			data[r][fmt.Sprintf("c%d", i)] = cell
+		}
+	}
+
+	return cardElement{
+		"tag":          "table",
+		"page_size":    10,
+		"row_height":   "low",
+		"header_style": map[string]interface{}{"bold": true, "background_style": "grey"},
+		"columns":      columns,
+		"rows":         data,
+	}
+}
+
+// splitTableRow splits "| a | b |" into its cells
+func splitTableRow(line string) []string {
+	line = strings.TrimPrefix(strings.TrimSuffix(line, "|"), "|")
+	cells := strings.Split(line, "|")
+	for i, cell := range cells {
+		cells[i] = strings.TrimSpace(cell)
+	}
+	return cells
+}
+
+// cardSet remembers which sent messages are cards, since a message is
+// edited with a different API depending on its type, and the buttons
+// shown under each card
+type cardSet struct {
+	mu        sync.Mutex
+	ids       map[string]*sentCard
+	lastPrune time.Time
+}
+
+type sentCard struct {
+	sent    time.Time
+	buttons []im.Button
+}
+
+func newCardSet() *cardSet {
+	return &cardSet{ids: make(map[string]*sentCard), lastPrune: time.Now()}
+}
+
+func (s *cardSet) add(messageID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.ids[messageID] = &sentCard{sent: now}
+	if now.Sub(s.lastPrune) > time.Hour {
+		for id, card := range s.ids {
+			if now.Sub(card.sent) > cardRetention {
+				delete(s.ids, id)
+			}
+		}
+		s.lastPrune = now
+	}
+}
+
+func (s *cardSet) has(messageID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.ids[messageID]
+	return ok
+}
+
+// setButtons replaces the buttons of a card. It reports false when
+// messageID is not a card.
+func (s *cardSet) setButtons(messageID string, buttons []im.Button) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	card, ok := s.ids[messageID]
+	if ok {
+		card.buttons = buttons
+	}
+	return ok
+}
+
+func (s *cardSet) buttons(messageID string) []im.Button {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if card, ok := s.ids[messageID]; ok {
+		return card.buttons
+	}
+	return nil
+}
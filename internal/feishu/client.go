@@ -1,120 +1,261 @@
 package feishu
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 
+	"github.com/google/uuid"
 	lark "github.com/larksuite/oapi-sdk-go/v3"
 	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
 	"github.com/larksuite/oapi-sdk-go/v3/event/dispatcher"
-	larkws "github.com/larksuite/oapi-sdk-go/v3/ws"
+	larkcontact "github.com/larksuite/oapi-sdk-go/v3/service/contact/v3"
 	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+	larkws "github.com/larksuite/oapi-sdk-go/v3/ws"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
 )
 
-// MessageHandler is called when a message is received
-type MessageHandler func(msg *Message) error
+// Platform is the adapter name used in session keys
+const Platform = "feishu"
 
-// Message represents a received message
-type Message struct {
-	MessageID   string
-	ChatID      string
-	ChatType    string
-	Content     string
-	Mentions    []Mention
-}
+// Event delivery modes
+const (
+	// ModeWebSocket receives events over the larkws long connection
+	ModeWebSocket = "websocket"
+	// ModeHTTP receives events on an HTTP callback URL
+	ModeHTTP = "http"
+)
 
-// Mention represents a user mention
-type Mention struct {
-	Key       string
-	ID        string
-	Name      string
-	TenantKey string
+// Reply formats
+const (
+	// ReplyFormatCard renders Markdown replies as interactive cards
+	ReplyFormatCard = "card"
+	// ReplyFormatText sends replies as plain text messages
+	ReplyFormatText = "text"
+)
+
+// Options configures how events are received and replies are sent
+type Options struct {
+	// Mode is ModeWebSocket (default) or ModeHTTP
+	Mode string
+	// Listen is the address of the callback server in HTTP mode, e.g. ":8091"
+	Listen string
+	// Path is the callback URL path, defaults to /feishu/events
+	Path string
+	// VerificationToken and EncryptKey are set on the app's "事件订阅" page
+	VerificationToken string
+	EncryptKey        string
+	// ReplyFormat is ReplyFormatCard (default) or ReplyFormatText
+	ReplyFormat string
+	// APIBase overrides the open platform endpoint, e.g. for a local fake
+	APIBase string
 }
 
-// Client is a Feishu WebSocket client
+// Client is a Feishu client. It receives events over WebSocket or an HTTP
+// callback URL and replies through the message API.
 type Client struct {
 	appID     string
 	appSecret string
+	opts      Options
 	client    *lark.Client
 	wsClient  *larkws.Client
-	handler   MessageHandler
+	events    *dispatcher.EventDispatcher
+	handler   im.Handler
+	cards     *cardSet
+	bot       botIdentity
+	limits    *throttle
+	chats     *messageChats
 }
 
 // NewClient creates a new Feishu client
-func NewClient(appID, appSecret string, handler MessageHandler) *Client {
-	client := lark.NewClient(appID, appSecret,
+func NewClient(appID, appSecret string, opts Options) *Client {
+	clientOpts := []lark.ClientOptionFunc{
 		lark.WithLogLevel(larkcore.LogLevelInfo),
-	)
+		lark.WithHttpClient(newLimitedHTTPClient()),
+	}
+	if opts.APIBase != "" {
+		clientOpts = append(clientOpts, lark.WithOpenBaseUrl(opts.APIBase))
+	}
+	client := lark.NewClient(appID, appSecret, clientOpts...)
+
+	if opts.Mode == "" {
+		opts.Mode = ModeWebSocket
+	}
+	if opts.Path == "" {
+		opts.Path = "/feishu/events"
+	}
 
-	return &Client{
+	c := &Client{
 		appID:     appID,
 		appSecret: appSecret,
+		opts:      opts,
 		client:    client,
-		handler:   handler,
+		cards:     newCardSet(),
+		limits:    newThrottle(),
+		chats:     newMessageChats(),
 	}
+	c.events = dispatcher.NewEventDispatcher(opts.VerificationToken, opts.EncryptKey).
+		OnP2MessageReceiveV1(c.handleMessage).
+		OnP2CardActionTrigger(c.handleCardAction)
+
+	return c
+}
+
+// Platform returns the adapter name
+func (c *Client) Platform() string {
+	return Platform
 }
 
-// Start starts the WebSocket client
-func (c *Client) Start(ctx context.Context) error {
-	eventHandler := dispatcher.NewEventDispatcher("", "").
-		OnP2MessageReceiveV1(c.handleMessage)
+// Start receives events in the configured mode and passes received
+// messages to handler
+func (c *Client) Start(ctx context.Context, handler im.Handler) error {
+	c.handler = handler
+
+	// Look up the bot up front so the first group message is not delayed
+	c.botOpenID(ctx)
+
+	if c.opts.Mode == ModeHTTP {
+		return c.serveHTTP(ctx)
+	}
 
 	wsClient := larkws.NewClient(c.appID, c.appSecret,
-		larkws.WithEventHandler(eventHandler),
+		larkws.WithEventHandler(c.events),
 		larkws.WithLogLevel(larkcore.LogLevelInfo),
 	)
 
 	c.wsClient = wsClient
 
 	log.Printf("[Feishu] Starting WebSocket client (appId=%s)", c.appID)
-	return wsClient.Start(ctx)
+
+	// The SDK client never returns once connected, so stop waiting on it
+	// when ctx is done
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- wsClient.Start(ctx)
+	}()
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+		return nil
+	}
 }
 
 // handleMessage handles incoming messages
 func (c *Client) handleMessage(ctx context.Context, event *larkim.P2MessageReceiveV1) error {
+	// The SDK only checks the token on url_verification, so check it on
+	// every event that reaches the public callback URL
+	if c.opts.Mode == ModeHTTP && !c.validToken(event.EventV2Base) {
+		log.Printf("[Feishu] Dropped event with invalid verification token")
+		return nil
+	}
+
 	msg := event.Event.Message
 
-	// Only handle text messages
-	if msg.MessageType == nil || *msg.MessageType != "text" {
+	if msg.MessageType == nil || msg.Content == nil {
 		return nil
 	}
 
-	if msg.Content == nil {
+	var text string
+	var resources []im.Resource
+	switch *msg.MessageType {
+	case "text":
+		var content struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal([]byte(*msg.Content), &content); err != nil {
+			log.Printf("[Feishu] Failed to parse message content: %v", err)
+			return nil
+		}
+		text = content.Text
+	case "post":
+		// Rich text is forwarded as Markdown so paragraphs, links and
+		// code blocks reach the agent intact
+		markdown, imageKeys, err := parsePost(*msg.Content)
+		if err != nil {
+			log.Printf("[Feishu] Failed to parse post content: %v", err)
+			return nil
+		}
+		text = markdown
+		for _, key := range imageKeys {
+			resources = append(resources, im.Resource{Type: im.ResourceImage, Key: key})
+		}
+	case "image":
+		var content struct {
+			ImageKey string `json:"image_key"`
+		}
+		if err := json.Unmarshal([]byte(*msg.Content), &content); err != nil || content.ImageKey == "" {
+			log.Printf("[Feishu] Failed to parse image content: %s", *msg.Content)
+			return nil
+		}
+		text = "[图片]"
+		resources = append(resources, im.Resource{Type: im.ResourceImage, Key: content.ImageKey})
+	case "file":
+		// File messages have no text; the bridge links them to the next
+		// message in the chat
+		var content struct {
+			FileKey  string `json:"file_key"`
+			FileName string `json:"file_name"`
+		}
+		if err := json.Unmarshal([]byte(*msg.Content), &content); err != nil || content.FileKey == "" {
+			log.Printf("[Feishu] Failed to parse file content: %s", *msg.Content)
+			return nil
+		}
+		resources = append(resources, im.Resource{Type: im.ResourceFile, Key: content.FileKey, Name: content.FileName})
+	default:
 		return nil
 	}
 
-	// Parse message content
-	var content struct {
-		Text string `json:"text"`
-	}
-	if err := json.Unmarshal([]byte(*msg.Content), &content); err != nil {
-		log.Printf("[Feishu] Failed to parse message content: %v", err)
-		return nil
+	// Topic groups behave like groups where every message starts a thread
+	chatType := getStringValue(msg.ChatType)
+	if chatType == "topic_group" {
+		chatType = im.ChatTypeGroup
 	}
 
 	// Build message
-	message := &Message{
+	message := &im.Message{
+		Platform:  Platform,
 		MessageID: getStringValue(msg.MessageId),
+		EventID:   eventID(event.EventV2Base),
 		ChatID:    getStringValue(msg.ChatId),
-		ChatType:  getStringValue(msg.ChatType),
-		Content:   content.Text,
+		ChatType:  chatType,
+		Content:   text,
+		ThreadID:  getStringValue(msg.RootId),
+		Resources: resources,
+	}
+	c.chats.add(message.MessageID, message.ChatID)
+	if sender := event.Event.Sender; sender != nil {
+		message.Sender.Type = getStringValue(sender.SenderType)
+		message.Sender.TenantKey = getStringValue(sender.TenantKey)
+		if sender.SenderId != nil {
+			message.Sender.ID = getStringValue(sender.SenderId.OpenId)
+			message.Sender.UnionID = getStringValue(sender.SenderId.UnionId)
+			message.Sender.UserID = getStringValue(sender.SenderId.UserId)
+		}
 	}
 
-	// Parse mentions
-	if msg.Mentions != nil {
+	// Parse mentions. Mentions carry the open_id of the mentioned user or
+	// bot; user_id is only set when the app may read user IDs.
+	if len(msg.Mentions) > 0 {
+		botID := c.botOpenID(ctx)
 		for _, mention := range msg.Mentions {
-			mentionID := ""
-			if mention.Id != nil && mention.Id.UserId != nil {
-				mentionID = *mention.Id.UserId
-			}
-			message.Mentions = append(message.Mentions, Mention{
+			m := im.Mention{
 				Key:       getStringValue(mention.Key),
-				ID:        mentionID,
 				Name:      getStringValue(mention.Name),
 				TenantKey: getStringValue(mention.TenantKey),
-			})
+			}
+			if mention.Id != nil {
+				m.ID = getStringValue(mention.Id.OpenId)
+				m.UnionID = getStringValue(mention.Id.UnionId)
+				m.UserID = getStringValue(mention.Id.UserId)
+			}
+			// Without the bot's open_id, fall back to treating every
+			// mention as addressed to the bot
+			m.Bot = botID == "" || m.ID == botID
+			message.Mentions = append(message.Mentions, m)
 		}
 	}
 
@@ -126,72 +267,278 @@ func (c *Client) handleMessage(ctx context.Context, event *larkim.P2MessageRecei
 	return nil
 }
 
-// SendMessage sends a text message to a chat
-func (c *Client) SendMessage(chatID, text string) (string, error) {
+// SendMessage sends a reply to a chat
+func (c *Client) SendMessage(ctx context.Context, chatID, text string) (string, error) {
+	return c.post(text, func(msgType, content string) (string, error) {
+		return c.createMessage(ctx, chatID, msgType, content)
+	})
+}
+
+// ReplyMessage replies to a message, in its thread when inThread is set
+func (c *Client) ReplyMessage(ctx context.Context, messageID, text string, inThread bool) (string, error) {
+	return c.post(text, func(msgType, content string) (string, error) {
+		return c.replyMessage(ctx, messageID, msgType, content, inThread)
+	})
+}
+
+// post renders text and hands it to send. Replies are rendered from
+// Markdown into an interactive card unless ReplyFormat is "text"; a card
+// that Feishu rejects is sent again as plain text.
+func (c *Client) post(text string, send func(msgType, content string) (string, error)) (string, error) {
+	if c.opts.ReplyFormat != ReplyFormatText {
+		content, err := renderCard(text)
+		if err == nil {
+			var messageID string
+			messageID, err = send("interactive", content)
+			if err == nil {
+				c.cards.add(messageID)
+				return messageID, nil
+			}
+			// Sending it as text would fail the same way
+			if IsRetryable(err) {
+				return "", err
+			}
+		}
+		log.Printf("[Feishu] Card rejected, sending as text: %v", err)
+	}
+
+	return send("text", textContent(text))
+}
+
+// Request size limits of the message API, with headroom for the JSON around
+// the text
+const (
+	maxCardBytes = 20 << 10
+	maxTextBytes = 100 << 10
+)
+
+// MaxMessageBytes is the longest reply that fits in one message
+func (c *Client) MaxMessageBytes() int {
+	if c.opts.ReplyFormat == ReplyFormatText {
+		return maxTextBytes
+	}
+	return maxCardBytes
+}
+
+// SendFile uploads data and sends it to a chat as a file message
+func (c *Client) SendFile(ctx context.Context, chatID, name string, data []byte) (string, error) {
+	req := larkim.NewCreateFileReqBuilder().
+		Body(larkim.NewCreateFileReqBodyBuilder().
+			FileType("stream").
+			FileName(name).
+			File(bytes.NewReader(data)).
+			Build()).
+		Build()
+
+	fileKey := ""
+	err := c.retry(ctx, []string{appLimitKey}, func() error {
+		resp, err := c.client.Im.File.Create(ctx, req)
+		if err != nil {
+			return &transportError{op: "upload file", err: err}
+		}
+		if !resp.Success() {
+			return newAPIError("upload file", resp.ApiResp, resp.CodeError)
+		}
+		if resp.Data != nil && resp.Data.FileKey != nil {
+			fileKey = *resp.Data.FileKey
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return c.createMessage(ctx, chatID, "file", fmt.Sprintf(`{"file_key":"%s"}`, escapeJSON(fileKey)))
+}
+
+// createMessage sends a message of any type to a chat
+func (c *Client) createMessage(ctx context.Context, chatID, msgType, content string) (string, error) {
 	req := larkim.NewCreateMessageReqBuilder().
 		ReceiveIdType("chat_id").
 		Body(larkim.NewCreateMessageReqBodyBuilder().
 			ReceiveId(chatID).
-			MsgType("text").
-			Content(fmt.Sprintf(`{"text":"%s"}`, escapeJSON(text))).
+			MsgType(msgType).
+			Content(content).
+			Uuid(uuid.NewString()).
 			Build()).
 		Build()
 
-	resp, err := c.client.Im.Message.Create(context.Background(), req)
-	if err != nil {
-		return "", fmt.Errorf("failed to send message: %w", err)
-	}
+	messageID := ""
+	err := c.retry(ctx, []string{appLimitKey, chatLimitKey(chatID)}, func() error {
+		resp, err := c.client.Im.Message.Create(ctx, req)
+		if err != nil {
+			return &transportError{op: "send message", err: err}
+		}
+		if !resp.Success() {
+			return newAPIError("send message", resp.ApiResp, resp.CodeError)
+		}
+		if resp.Data != nil && resp.Data.MessageId != nil {
+			messageID = *resp.Data.MessageId
+		}
+		return nil
+	})
+	c.chats.add(messageID, chatID)
 
-	if !resp.Success() {
-		return "", fmt.Errorf("failed to send message: %s", resp.Msg)
+	return messageID, err
+}
+
+// replyMessage sends a reply of any type to a message
+func (c *Client) replyMessage(ctx context.Context, messageID, msgType, content string, inThread bool) (string, error) {
+	req := larkim.NewReplyMessageReqBuilder().
+		MessageId(messageID).
+		Body(larkim.NewReplyMessageReqBodyBuilder().
+			MsgType(msgType).
+			Content(content).
+			ReplyInThread(inThread).
+			Uuid(uuid.NewString()).
+			Build()).
+		Build()
+
+	replyID := ""
+	err := c.retry(ctx, c.replyLimitKeys(messageID), func() error {
+		resp, err := c.client.Im.Message.Reply(ctx, req)
+		if err != nil {
+			return &transportError{op: "reply to message", err: err}
+		}
+		if !resp.Success() {
+			return newAPIError("reply to message", resp.ApiResp, resp.CodeError)
+		}
+		if resp.Data != nil && resp.Data.MessageId != nil {
+			replyID = *resp.Data.MessageId
+			c.chats.add(replyID, getStringValue(resp.Data.ChatId))
+		}
+		return nil
+	})
+
+	return replyID, err
+}
+
+// UpdateMessage updates an existing message. Cards are re-rendered, and shown
+// as plain text if the rendered card is rejected; a message type cannot change.
+func (c *Client) UpdateMessage(ctx context.Context, messageID, text string) error {
+	if !c.cards.has(messageID) {
+		return c.updateText(ctx, messageID, text)
 	}
 
-	messageID := ""
-	if resp.Data != nil && resp.Data.MessageId != nil {
-		messageID = *resp.Data.MessageId
+	buttons := c.cards.buttons(messageID)
+	content, err := renderCard(text, buttons...)
+	if err == nil {
+		if err = c.patchCard(ctx, messageID, content); err == nil || IsRetryable(err) {
+			return err
+		}
 	}
+	log.Printf("[Feishu] Card update rejected, showing plain text: %v", err)
 
-	return messageID, nil
+	content, err = plainCard(text, buttons...)
+	if err != nil {
+		return err
+	}
+	return c.patchCard(ctx, messageID, content)
 }
 
-// UpdateMessage updates an existing message
-func (c *Client) UpdateMessage(messageID, text string) error {
+// updateText replaces the content of a text message
+func (c *Client) updateText(ctx context.Context, messageID, text string) error {
 	req := larkim.NewUpdateMessageReqBuilder().
 		MessageId(messageID).
 		Body(larkim.NewUpdateMessageReqBodyBuilder().
 			MsgType("text").
-			Content(fmt.Sprintf(`{"text":"%s"}`, escapeJSON(text))).
+			Content(textContent(text)).
+			Build()).
+		Build()
+
+	return c.retry(ctx, c.updateLimitKeys(messageID), func() error {
+		resp, err := c.client.Im.Message.Update(ctx, req)
+		if err != nil {
+			return &transportError{op: "update message", err: err}
+		}
+		if !resp.Success() {
+			return newAPIError("update message", resp.ApiResp, resp.CodeError)
+		}
+		return nil
+	})
+}
+
+// patchCard replaces the content of an interactive card
+func (c *Client) patchCard(ctx context.Context, messageID, content string) error {
+	req := larkim.NewPatchMessageReqBuilder().
+		MessageId(messageID).
+		Body(larkim.NewPatchMessageReqBodyBuilder().
+			Content(content).
 			Build()).
 		Build()
 
-	resp, err := c.client.Im.Message.Update(context.Background(), req)
+	return c.retry(ctx, c.updateLimitKeys(messageID), func() error {
+		resp, err := c.client.Im.Message.Patch(ctx, req)
+		if err != nil {
+			return &transportError{op: "update card", err: err}
+		}
+		if !resp.Success() {
+			return newAPIError("update card", resp.ApiResp, resp.CodeError)
+		}
+		return nil
+	})
+}
+
+// DeleteMessage deletes a message
+func (c *Client) DeleteMessage(ctx context.Context, messageID string) error {
+	req := larkim.NewDeleteMessageReqBuilder().
+		MessageId(messageID).
+		Build()
+
+	return c.retry(ctx, []string{appLimitKey}, func() error {
+		resp, err := c.client.Im.Message.Delete(ctx, req)
+		if err != nil {
+			return &transportError{op: "delete message", err: err}
+		}
+		if !resp.Success() {
+			return newAPIError("delete message", resp.ApiResp, resp.CodeError)
+		}
+		return nil
+	})
+}
+
+// UserName looks up the name of a user by open_id. It needs the
+// contact:user.base:readonly permission.
+func (c *Client) UserName(ctx context.Context, openID string) (string, error) {
+	req := larkcontact.NewGetUserReqBuilder().
+		UserId(openID).
+		UserIdType("open_id").
+		Build()
+
+	resp, err := c.client.Contact.User.Get(ctx, req)
 	if err != nil {
-		return fmt.Errorf("failed to update message: %w", err)
+		return "", &transportError{op: "get user", err: err}
 	}
 
 	if !resp.Success() {
-		return fmt.Errorf("failed to update message: %s", resp.Msg)
+		return "", newAPIError("get user", resp.ApiResp, resp.CodeError)
 	}
 
-	return nil
+	if resp.Data == nil || resp.Data.User == nil {
+		return "", nil
+	}
+	return getStringValue(resp.Data.User.Name), nil
 }
 
-// DeleteMessage deletes a message
-func (c *Client) DeleteMessage(messageID string) error {
-	req := larkim.NewDeleteMessageReqBuilder().
-		MessageId(messageID).
+// ChatName looks up the name of a group chat
+func (c *Client) ChatName(ctx context.Context, chatID string) (string, error) {
+	req := larkim.NewGetChatReqBuilder().
+		ChatId(chatID).
 		Build()
 
-	resp, err := c.client.Im.Message.Delete(context.Background(), req)
+	resp, err := c.client.Im.Chat.Get(ctx, req)
 	if err != nil {
-		return fmt.Errorf("failed to delete message: %w", err)
+		return "", &transportError{op: "get chat", err: err}
 	}
 
 	if !resp.Success() {
-		return fmt.Errorf("failed to delete message: %s", resp.Msg)
+		return "", newAPIError("get chat", resp.ApiResp, resp.CodeError)
 	}
 
-	return nil
+	if resp.Data == nil {
+		return "", nil
+	}
+	return getStringValue(resp.Data.Name), nil
 }
 
 // Helper functions
@@ -203,6 +550,11 @@ func getStringValue(s *string) string {
 	return *s
 }
 
+// textContent is the content of a text message
+func textContent(text string) string {
+	return fmt.Sprintf(`{"text":"%s"}`, escapeJSON(text))
+}
+
 func escapeJSON(s string) string {
 	b, _ := json.Marshal(s)
 	// Remove surrounding quotes
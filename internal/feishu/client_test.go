@@ -0,0 +1,257 @@
+package feishu
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+// encryptEvent encrypts a payload the way Feishu does when an encrypt key is set
+func encryptEvent(t *testing.T, key, plaintext string) string {
+	t.Helper()
+
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := aes.BlockSize - len(plaintext)%aes.BlockSize
+	data := append([]byte(plaintext), bytes.Repeat([]byte{byte(n)}, n)...)
+
+	iv := []byte("0123456789abcdef")
+	out := make([]byte, len(data))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, data)
+
+	return base64.StdEncoding.EncodeToString(append(iv, out...))
+}
+
+func postEvent(c *Client, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	c.eventHandlerFunc()(rec, httptest.NewRequest(http.MethodPost, "/feishu/events", strings.NewReader(body)))
+	return rec
+}
+
+func messageEvent(token string) string {
+	return `{"schema":"2.0","header":{"event_id":"ev-1","event_type":"im.message.receive_v1","token":"` + token + `"},` +
+		`"event":{"sender":{"sender_id":{"open_id":"ou_a","union_id":"on_a","user_id":"u-a"},"sender_type":"user","tenant_key":"tk-1"},` +
+		`"message":{"message_id":"om_1","root_id":"om_0","chat_id":"oc_1","chat_type":"group","message_type":"text",` +
+		`"content":"{\"text\":\"@_user_1 帮我看看 @_user_2\"}","mentions":[` +
+		`{"key":"@_user_1","name":"bot","id":{"open_id":"ou_bot"}},` +
+		`{"key":"@_user_2","name":"李四","id":{"open_id":"ou_b","union_id":"on_b","user_id":"u-b"}}]}}}`
+}
+
+func TestHTTPURLVerification(t *testing.T) {
+	client := NewClient("cli_test", "secret", Options{Mode: ModeHTTP, VerificationToken: "v-token"})
+
+	rec := postEvent(client, `{"type":"url_verification","challenge":"ch-1","token":"v-token"}`)
+	var resp struct {
+		Challenge string `json:"challenge"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusOK || resp.Challenge != "ch-1" {
+		t.Fatalf("verification = %d %s, want 200 with challenge ch-1", rec.Code, rec.Body.String())
+	}
+
+	rec = postEvent(client, `{"type":"url_verification","challenge":"ch-1","token":"forged"}`)
+	if rec.Code == http.StatusOK {
+		t.Fatal("verification with a forged token succeeded")
+	}
+}
+
+func TestHTTPMessageEvent(t *testing.T) {
+	server := fakeOpenAPI(t, nil)
+	client := NewClient("cli_test", "secret", Options{Mode: ModeHTTP, VerificationToken: "v-token", APIBase: server.URL})
+
+	var received []*im.Message
+	client.handler = func(msg *im.Message) error {
+		received = append(received, msg)
+		return nil
+	}
+
+	if rec := postEvent(client, messageEvent("v-token")); rec.Code != http.StatusOK {
+		t.Fatalf("event status = %d %s, want 200", rec.Code, rec.Body.String())
+	}
+	postEvent(client, messageEvent("forged"))
+
+	if len(received) != 1 {
+		t.Fatalf("handler called %d times, want once for the valid token", len(received))
+	}
+	msg := received[0]
+	if msg.Platform != Platform || msg.MessageID != "om_1" || msg.EventID != "ev-1" || msg.ChatID != "oc_1" || msg.ChatType != im.ChatTypeGroup ||
+		msg.Sender.ID != "ou_a" || msg.Sender.UnionID != "on_a" || msg.Sender.UserID != "u-a" || msg.Sender.Type != "user" || msg.Sender.TenantKey != "tk-1" || msg.ThreadID != "om_0" {
+		t.Fatalf("message = %+v", msg)
+	}
+	if msg.Content != "@_user_1 帮我看看 @_user_2" || len(msg.Mentions) != 2 {
+		t.Fatalf("message content = %q mentions = %+v", msg.Content, msg.Mentions)
+	}
+	if bot := msg.Mentions[0]; bot.ID != "ou_bot" || !bot.Bot {
+		t.Fatalf("bot mention = %+v", bot)
+	}
+	if other := msg.Mentions[1]; other.ID != "ou_b" || other.UnionID != "on_b" || other.UserID != "u-b" || other.Bot {
+		t.Fatalf("other mention = %+v", other)
+	}
+}
+
+func TestHTTPEncryptedEvent(t *testing.T) {
+	server := fakeOpenAPI(t, nil)
+	client := NewClient("cli_test", "secret", Options{Mode: ModeHTTP, VerificationToken: "v-token", EncryptKey: "e-key", APIBase: server.URL})
+
+	received := 0
+	client.handler = func(msg *im.Message) error {
+		received++
+		return nil
+	}
+
+	challenge, _ := json.Marshal(map[string]string{
+		"encrypt": encryptEvent(t, "e-key", `{"type":"url_verification","challenge":"ch-2","token":"v-token"}`),
+	})
+	rec := postEvent(client, string(challenge))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "ch-2") {
+		t.Fatalf("encrypted verification = %d %s, want 200 with challenge ch-2", rec.Code, rec.Body.String())
+	}
+
+	// Events are signed when an encrypt key is set; an unsigned one is rejected
+	event, _ := json.Marshal(map[string]string{"encrypt": encryptEvent(t, "e-key", messageEvent("v-token"))})
+	if rec := postEvent(client, string(event)); rec.Code == http.StatusOK || received != 0 {
+		t.Fatalf("unsigned event = %d, handler called %d times, want rejection", rec.Code, received)
+	}
+}
+
+func TestParsePost(t *testing.T) {
+	content := `{"title":"部署失败","content":[` +
+		`[{"tag":"at","user_id":"@_user_1","user_name":"bot"},{"tag":"text","text":" 帮我看看 "},{"tag":"text","text":"这个报错","style":["bold"]}],` +
+		`[{"tag":"text","text":"文档："},{"tag":"a","text":"runbook","href":"https://example.com/runbook"}],` +
+		`[{"tag":"code_block","language":"GO","text":"panic: nil map\n"}],` +
+		`[{"tag":"img","image_key":"img_v2_1"}]]}`
+
+	got, images, err := parsePost(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# 部署失败\n\n" +
+		"@_user_1 帮我看看 **这个报错**\n" +
+		"文档：[runbook](https://example.com/runbook)\n" +
+		"```go\npanic: nil map\n```\n\n" +
+		"[图片]"
+	if got != want {
+		t.Fatalf("parsePost() =\n%s\nwant\n%s", got, want)
+	}
+	if len(images) != 1 || images[0] != "img_v2_1" {
+		t.Fatalf("parsePost() images = %v, want [img_v2_1]", images)
+	}
+
+	// Some clients wrap the post in a locale key
+	got, _, err = parsePost(`{"zh_cn":{"title":"","content":[[{"tag":"text","text":"你好"}]]}}`)
+	if err != nil || got != "你好" {
+		t.Fatalf("parsePost(locale) = %q, %v", got, err)
+	}
+}
+
+func TestRenderCard(t *testing.T) {
+	markdown := "## 结论\n" +
+		"详见 [文档](https://example.com) ![截图](https://example.com/a.png)\n" +
+		"```go\nfmt.Println(\"| not a table |\")\n```\n" +
+		"| 服务 | 状态 |\n|---|:---:|\n| api | **正常** |\n| db |\n" +
+		"---\n" +
+		"```sh\nkubectl get pods"
+
+	content, err := renderCard(markdown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var card struct {
+		Config   map[string]bool          `json:"config"`
+		Elements []map[string]interface{} `json:"elements"`
+	}
+	if err := json.Unmarshal([]byte(content), &card); err != nil {
+		t.Fatalf("renderCard() produced invalid JSON: %v", err)
+	}
+	if !card.Config["update_multi"] {
+		t.Fatal("card is not updatable")
+	}
+
+	var tags []string
+	for _, el := range card.Elements {
+		tags = append(tags, el["tag"].(string))
+	}
+	if strings.Join(tags, ",") != "markdown,table,hr,markdown" {
+		t.Fatalf("elements = %v, want markdown,table,hr,markdown", tags)
+	}
+
+	wantText := "**结论**\n详见 [文档](https://example.com) [截图](https://example.com/a.png)\n" +
+		"```go\nfmt.Println(\"| not a table |\")\n```"
+	if card.Elements[0]["content"] != wantText {
+		t.Fatalf("first element = %q, want %q", card.Elements[0]["content"], wantText)
+	}
+
+	rows := card.Elements[1]["rows"].([]interface{})
+	if len(rows) != 2 || rows[0].(map[string]interface{})["c1"] != "**正常**" || rows[1].(map[string]interface{})["c1"] != "" {
+		t.Fatalf("table rows = %v", rows)
+	}
+
+	// An unterminated fence while streaming is closed at the end
+	if last := card.Elements[3]["content"]; last != "```sh\nkubectl get pods\n```" {
+		t.Fatalf("last element = %q", last)
+	}
+}
+
+// fakeOpenAPI serves the bot info and message APIs and rejects
+// interactive cards
+func fakeOpenAPI(t *testing.T, sent chan<- string) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/open-apis/auth/v3/tenant_access_token/internal", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"code":0,"msg":"ok","tenant_access_token":"t-1","expire":7200}`))
+	})
+	mux.HandleFunc("/open-apis/bot/v3/info", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"code":0,"msg":"ok","bot":{"app_name":"bot","open_id":"ou_bot"}}`))
+	})
+	mux.HandleFunc("/open-apis/im/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			MsgType string `json:"msg_type"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		sent <- body.MsgType
+
+		w.Header().Set("Content-Type", "application/json")
+		if body.MsgType == "interactive" {
+			w.Write([]byte(`{"code":230099,"msg":"Failed to create card content"}`))
+			return
+		}
+		w.Write([]byte(`{"code":0,"msg":"ok","data":{"message_id":"om_text"}}`))
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestSendMessageFallsBackToText(t *testing.T) {
+	sent := make(chan string, 4)
+	server := fakeOpenAPI(t, sent)
+	client := NewClient("cli_test", "secret", Options{APIBase: server.URL})
+
+	messageID, err := client.SendMessage(context.Background(), "oc_1", "**你好**")
+	if err != nil {
+		t.Fatalf("SendMessage() error: %v", err)
+	}
+	if messageID != "om_text" || client.cards.has(messageID) {
+		t.Fatalf("SendMessage() = %q, want the text fallback om_text", messageID)
+	}
+	if first, second := <-sent, <-sent; first != "interactive" || second != "text" {
+		t.Fatalf("sent %s then %s, want interactive then text", first, second)
+	}
+}
@@ -0,0 +1,99 @@
+package feishu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+// bodyLimitKey is the context key of the largest response body a request
+// may read
+type bodyLimitKey struct{}
+
+// limitedHTTPClient enforces the body limit of a request's context. The SDK
+// reads whole responses into memory, so a download has to be cut off while
+// the body is read rather than afterwards.
+type limitedHTTPClient struct {
+	client larkcore.HttpClient
+}
+
+func newLimitedHTTPClient() limitedHTTPClient {
+	return limitedHTTPClient{client: http.DefaultClient}
+}
+
+func (c limitedHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	max, ok := req.Context().Value(bodyLimitKey{}).(int64)
+	if !ok || resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+	if resp.ContentLength > max {
+		resp.Body.Close()
+		return nil, tooLarge(max)
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, max: max}
+	return resp, nil
+}
+
+// limitedBody fails once more than max bytes have been read
+type limitedBody struct {
+	io.ReadCloser
+	max  int64
+	read int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if left := b.max - b.read + 1; int64(len(p)) > left {
+		p = p[:left]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if b.read += int64(n); b.read > b.max {
+		return n, tooLarge(b.max)
+	}
+	return n, err
+}
+
+func tooLarge(max int64) error {
+	return fmt.Errorf("%w: over %d bytes", im.ErrTooLarge, max)
+}
+
+// DownloadResource downloads an image or file attached to a received
+// message, failing once it exceeds maxBytes
+func (c *Client) DownloadResource(ctx context.Context, messageID string, res im.Resource, maxBytes int64) ([]byte, error) {
+	req := larkim.NewGetMessageResourceReqBuilder().
+		MessageId(messageID).
+		FileKey(res.Key).
+		Type(res.Type).
+		Build()
+
+	resp, err := c.client.Im.MessageResource.Get(context.WithValue(ctx, bodyLimitKey{}, maxBytes), req)
+	if errors.Is(err, im.ErrTooLarge) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, &transportError{op: "download resource", err: err}
+	}
+
+	if !resp.Success() {
+		return nil, newAPIError("download resource", resp.ApiResp, resp.CodeError)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.File, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, tooLarge(maxBytes)
+	}
+	return data, nil
+}
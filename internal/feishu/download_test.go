@@ -0,0 +1,44 @@
+package feishu
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+func TestDownloadResourceStopsAtLimit(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), 64)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/open-apis/auth/v3/tenant_access_token/internal", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"code":0,"msg":"ok","tenant_access_token":"t-1","expire":7200}`))
+	})
+	mux.HandleFunc("/open-apis/im/v1/messages/om_1/resources/file-1", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		// Flushing first hides the size, as with a chunked response
+		if r.URL.Query().Get("type") == "file" {
+			w.(http.Flusher).Flush()
+		}
+		w.Write(body)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := NewClient("cli_test", "secret", Options{APIBase: server.URL})
+	for _, resType := range []string{im.ResourceImage, im.ResourceFile} {
+		res := im.Resource{Type: resType, Key: "file-1"}
+
+		data, err := client.DownloadResource(context.Background(), "om_1", res, 64)
+		if err != nil || !bytes.Equal(data, body) {
+			t.Fatalf("DownloadResource(%s, 64) = %d bytes, %v", resType, len(data), err)
+		}
+		if _, err := client.DownloadResource(context.Background(), "om_1", res, 63); !errors.Is(err, im.ErrTooLarge) {
+			t.Fatalf("DownloadResource(%s, 63) error = %v, want ErrTooLarge", resType, err)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package feishu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
+)
+
+// Error codes that mean the request was throttled and can be sent again
+const (
+	codeAppRateLimit  = 99991400 // 应用频率限制
+	codeSendRateLimit = 11232    // 发送消息频率超限
+	codeChatRateLimit = 230020   // 单聊天发送频率超限
+)
+
+// Retry policy for API calls
+const (
+	retryAttempts = 4
+	retryMaxDelay = 8 * time.Second
+)
+
+// retryDelay is the backoff before the second attempt, doubled for each
+// further attempt. Tests shorten it.
+var retryDelay = 500 * time.Millisecond
+
+// APIError is an error response from the open platform
+type APIError struct {
+	// Op describes the failed call, e.g. "send message"
+	Op string
+	// Code and Msg are the error code and message in the response body
+	Code int
+	Msg  string
+	// Status is the HTTP status code
+	Status int
+	// LogID identifies the request when asking Feishu support
+	LogID string
+	// RetryAfter is how long the server asked to wait, zero if it did not say
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("failed to %s: %s (code %d)", e.Op, e.Msg, e.Code)
+}
+
+// Retryable reports whether the same request may succeed when sent again
+func (e *APIError) Retryable() bool {
+	switch e.Code {
+	case codeAppRateLimit, codeSendRateLimit, codeChatRateLimit:
+		return true
+	}
+	return e.Status == http.StatusTooManyRequests || e.Status >= 500
+}
+
+// newAPIError builds an APIError from a response that is not a success
+func newAPIError(op string, resp *larkcore.ApiResp, codeErr larkcore.CodeError) *APIError {
+	e := &APIError{Op: op, Code: codeErr.Code, Msg: codeErr.Msg}
+	if resp != nil {
+		e.Status = resp.StatusCode
+		e.LogID = resp.LogId()
+		if reset, err := strconv.Atoi(resp.Header.Get("X-Ogw-Ratelimit-Reset")); err == nil && reset > 0 {
+			e.RetryAfter = time.Duration(reset) * time.Second
+		}
+	}
+	return e
+}
+
+// transportError wraps an error from sending the request itself, such as
+// a timeout or a response that is not JSON
+type transportError struct {
+	op  string
+	err error
+}
+
+func (e *transportError) Error() string {
+	return fmt.Sprintf("failed to %s: %v", e.op, e.err)
+}
+
+func (e *transportError) Unwrap() error {
+	return e.err
+}
+
+// IsRetryable reports whether err is a throttling, server or network error
+// that may go away when the request is sent again
+func IsRetryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable()
+	}
+	var tErr *transportError
+	return errors.As(err, &tErr) &&
+		!errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// retry calls fn until it succeeds, fails with an error that cannot be
+// retried or runs out of attempts. Each attempt first waits for the send
+// limits of keys.
+func (c *Client) retry(ctx context.Context, keys []string, fn func() error) error {
+	delay := retryDelay
+	for attempt := 1; ; attempt++ {
+		if err := c.limits.wait(ctx, keys...); err != nil {
+			return err
+		}
+		err := fn()
+		if err == nil || attempt == retryAttempts || !IsRetryable(err) {
+			return err
+		}
+
+		// Full jitter, but never sooner than the server asked for
+		wait := time.Duration(rand.Int63n(int64(delay)) + 1)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > wait {
+			wait = apiErr.RetryAfter
+		}
+		log.Printf("[Feishu] %v, retrying in %s (attempt %d/%d)", err, wait.Round(time.Millisecond), attempt+1, retryAttempts)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		if delay *= 2; delay > retryMaxDelay {
+			delay = retryMaxDelay
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package feishu
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// scriptedOpenAPI answers message requests with the given responses in
+// turn, repeating the last one, and records the uuid of each request
+func scriptedOpenAPI(t *testing.T, responses ...string) (*httptest.Server, *[]string) {
+	t.Helper()
+
+	old := retryDelay
+	retryDelay = time.Millisecond
+	t.Cleanup(func() { retryDelay = old })
+
+	var uuids []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/open-apis/auth/v3/tenant_access_token/internal", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"code":0,"msg":"ok","tenant_access_token":"t-1","expire":7200}`))
+	})
+	mux.HandleFunc("/open-apis/im/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			UUID string `json:"uuid"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		uuids = append(uuids, body.UUID)
+
+		resp := responses[len(responses)-1]
+		if len(uuids) <= len(responses) {
+			resp = responses[len(uuids)-1]
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(resp))
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, &uuids
+}
+
+func TestSendMessageRetriesRateLimit(t *testing.T) {
+	server, uuids := scriptedOpenAPI(t,
+		`{"code":99991400,"msg":"request trigger frequency limit"}`,
+		`{"code":0,"msg":"ok","data":{"message_id":"om_1"}}`,
+	)
+	client := NewClient("cli_test", "secret", Options{APIBase: server.URL, ReplyFormat: ReplyFormatText})
+
+	messageID, err := client.SendMessage(context.Background(), "oc_1", "你好")
+	if err != nil {
+		t.Fatalf("SendMessage() error: %v", err)
+	}
+	if messageID != "om_1" {
+		t.Fatalf("SendMessage() = %q, want om_1", messageID)
+	}
+	if len(*uuids) != 2 || (*uuids)[0] == "" || (*uuids)[0] != (*uuids)[1] {
+		t.Fatalf("requests = %q, want two with the same uuid", *uuids)
+	}
+}
+
+func TestSendMessageSurfacesPermanentError(t *testing.T) {
+	server, uuids := scriptedOpenAPI(t, `{"code":230002,"msg":"Bot/User can NOT be out of the chat."}`)
+	client := NewClient("cli_test", "secret", Options{APIBase: server.URL, ReplyFormat: ReplyFormatText})
+
+	_, err := client.SendMessage(context.Background(), "oc_1", "你好")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != 230002 || apiErr.Op != "send message" {
+		t.Fatalf("SendMessage() error = %v, want an APIError with code 230002", err)
+	}
+	if IsRetryable(err) || len(*uuids) != 1 {
+		t.Fatalf("sent %d requests for an error that cannot be retried", len(*uuids))
+	}
+}
+
+func TestCardRetriesDoNotFallBackToText(t *testing.T) {
+	server, uuids := scriptedOpenAPI(t, `{"code":230020,"msg":"This chat is too busy"}`)
+	client := NewClient("cli_test", "secret", Options{APIBase: server.URL})
+
+	_, err := client.SendMessage(context.Background(), "oc_1", "**你好**")
+	if !IsRetryable(err) {
+		t.Fatalf("SendMessage() error = %v, want the rate limit error", err)
+	}
+	if len(*uuids) != retryAttempts {
+		t.Fatalf("sent %d requests, want %d card attempts and no text fallback", len(*uuids), retryAttempts)
+	}
+}
+
+func TestAPIErrorRetryable(t *testing.T) {
+	tests := []struct {
+		err  *APIError
+		want bool
+	}{
+		{&APIError{Code: codeAppRateLimit, Status: 400}, true},
+		{&APIError{Code: codeSendRateLimit, Status: 200}, true},
+		{&APIError{Code: 1, Status: 503}, true},
+		{&APIError{Code: 0, Status: 429}, true},
+		{&APIError{Code: 230001, Status: 400}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.err.Retryable(); got != tt.want {
+			t.Errorf("Retryable(code %d, status %d) = %v, want %v", tt.err.Code, tt.err.Status, got, tt.want)
+		}
+	}
+
+	if IsRetryable(&transportError{op: "send message", err: context.Canceled}) {
+		t.Error("IsRetryable(canceled) = true, want false")
+	}
+	if !IsRetryable(&transportError{op: "send message", err: errors.New("connection reset")}) {
+		t.Error("IsRetryable(connection reset) = false, want true")
+	}
+}
@@ -0,0 +1,60 @@
+package feishu
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
+	"github.com/larksuite/oapi-sdk-go/v3/core/httpserverext"
+	larkevent "github.com/larksuite/oapi-sdk-go/v3/event"
+)
+
+// serveHTTP serves the event callback URL until ctx is done. The dispatcher
+// answers the url_verification challenge, checks the verification token and
+// decrypts events when an encrypt key is set.
+func (c *Client) serveHTTP(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(c.opts.Path, c.eventHandlerFunc())
+
+	server := &http.Server{
+		Addr:              c.opts.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("[Feishu] Listening for events on %s%s (appId=%s)", c.opts.Listen, c.opts.Path, c.appID)
+
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// validToken reports whether an event carries the configured verification token
+func (c *Client) validToken(base *larkevent.EventV2Base) bool {
+	return base != nil && base.Header != nil && base.Header.Token == c.opts.VerificationToken
+}
+
+// eventHandlerFunc returns the HTTP handler for the event callback URL
+func (c *Client) eventHandlerFunc() http.HandlerFunc {
+	return httpserverext.NewEventHandlerFunc(c.events,
+		larkevent.WithLogLevel(larkcore.LogLevelInfo),
+	)
+}
+
+// eventID returns the ID of an event, empty for events without a header
+func eventID(base *larkevent.EventV2Base) string {
+	if base == nil || base.Header == nil {
+		return ""
+	}
+	return base.Header.EventID
+}
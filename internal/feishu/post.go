@@ -0,0 +1,140 @@
+package feishu
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// postContent is the content of a rich-text (post) message. Received
+// messages carry title and content directly; some clients wrap them in a
+// locale key such as zh_cn.
+type postContent struct {
+	Title   string          `json:"title"`
+	Content [][]postElement `json:"content"`
+}
+
+// postElement is one inline element of a post paragraph
+type postElement struct {
+	Tag      string   `json:"tag"`
+	Text     string   `json:"text"`
+	Href     string   `json:"href"`
+	UserID   string   `json:"user_id"`
+	UserName string   `json:"user_name"`
+	Language string   `json:"language"`
+	Style    []string `json:"style"`
+	Emoji    string   `json:"emoji_type"`
+	ImageKey string   `json:"image_key"`
+}
+
+// parsePost converts post content into Markdown. Paragraphs become lines,
+// the title becomes a heading and code blocks keep their language. The keys
+// of embedded images are returned so they can be downloaded.
+func parsePost(raw string) (string, []string, error) {
+	post, err := decodePost(raw)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var b strings.Builder
+	if title := strings.TrimSpace(post.Title); title != "" {
+		b.WriteString("# " + title + "\n\n")
+	}
+
+	var imageKeys []string
+	for i, paragraph := range post.Content {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		for _, el := range paragraph {
+			writePostElement(&b, el)
+			if el.Tag == "img" && el.ImageKey != "" {
+				imageKeys = append(imageKeys, el.ImageKey)
+			}
+		}
+	}
+
+	return strings.TrimSpace(b.String()), imageKeys, nil
+}
+
+// decodePost accepts both the flat and the locale-wrapped post format
+func decodePost(raw string) (*postContent, error) {
+	var post postContent
+	if err := json.Unmarshal([]byte(raw), &post); err != nil {
+		return nil, fmt.Errorf("failed to parse post content: %w", err)
+	}
+	if post.Title != "" || len(post.Content) > 0 {
+		return &post, nil
+	}
+
+	var locales map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(raw), &locales); err != nil {
+		return nil, fmt.Errorf("failed to parse post content: %w", err)
+	}
+	for _, locale := range []string{"zh_cn", "en_us", "ja_jp"} {
+		if data, ok := locales[locale]; ok {
+			if err := json.Unmarshal(data, &post); err != nil {
+				return nil, fmt.Errorf("failed to parse post content: %w", err)
+			}
+			return &post, nil
+		}
+	}
+	return &post, nil
+}
+
+func writePostElement(b *strings.Builder, el postElement) {
+	switch el.Tag {
+	case "text":
+		b.WriteString(styleText(el.Text, el.Style))
+	case "a":
+		text := el.Text
+		if text == "" {
+			text = el.Href
+		}
+		fmt.Fprintf(b, "[%s](%s)", text, el.Href)
+	case "at":
+		// Keep the mention key (@_user_N) so mentions are handled the same
+		// way as in text messages
+		if el.UserID != "" {
+			b.WriteString(el.UserID)
+		} else {
+			b.WriteString("@" + el.UserName)
+		}
+	case "code_block":
+		// Code blocks always start on their own line
+		if b.Len() > 0 && !strings.HasSuffix(b.String(), "\n") {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(b, "```%s\n%s\n```\n", strings.ToLower(el.Language), strings.TrimRight(el.Text, "\n"))
+	case "md":
+		b.WriteString(el.Text)
+	case "hr":
+		b.WriteString("\n---\n")
+	case "emotion":
+		b.WriteString("[" + el.Emoji + "]")
+	case "img":
+		b.WriteString("[图片]")
+	case "media":
+		b.WriteString("[视频]")
+	default:
+		b.WriteString(el.Text)
+	}
+}
+
+// styleText applies the Markdown equivalent of post text styles
+func styleText(text string, styles []string) string {
+	if strings.TrimSpace(text) == "" {
+		return text
+	}
+	for _, style := range styles {
+		switch style {
+		case "bold":
+			text = "**" + text + "**"
+		case "italic":
+			text = "*" + text + "*"
+		case "lineThrough":
+			text = "~~" + text + "~~"
+		}
+	}
+	return text
+}
@@ -0,0 +1,184 @@
+package feishu
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Send limits of the message API. Feishu allows an app 50 requests per
+// second, and 5 per second to one chat or on one message.
+const (
+	appRate  = 50
+	chatRate = 5
+)
+
+// Keys of the send limits
+const appLimitKey = "app"
+
+func chatLimitKey(chatID string) string {
+	return "chat:" + chatID
+}
+
+func messageLimitKey(messageID string) string {
+	return "msg:" + messageID
+}
+
+// messageChatRetention is how long the chat of a message is remembered,
+// matching how long sent cards can be updated
+const messageChatRetention = cardRetention
+
+// messageChats remembers the chat of messages received and sent, so that
+// replies and edits, which only name a message, count against the limit of
+// its chat
+type messageChats struct {
+	mu        sync.Mutex
+	chats     map[string]messageChat
+	lastPrune time.Time
+}
+
+type messageChat struct {
+	chatID string
+	seen   time.Time
+}
+
+func newMessageChats() *messageChats {
+	return &messageChats{chats: make(map[string]messageChat), lastPrune: time.Now()}
+}
+
+func (m *messageChats) add(messageID, chatID string) {
+	if messageID == "" || chatID == "" {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.chats[messageID] = messageChat{chatID: chatID, seen: now}
+	if now.Sub(m.lastPrune) > time.Hour {
+		for id, entry := range m.chats {
+			if now.Sub(entry.seen) > messageChatRetention {
+				delete(m.chats, id)
+			}
+		}
+		m.lastPrune = now
+	}
+}
+
+// get returns the chat of messageID, "" when it is not known
+func (m *messageChats) get(messageID string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.chats[messageID].chatID
+}
+
+// replyLimitKeys are the send limits a reply to messageID counts against.
+// The message stands in for its chat when the chat is not known.
+func (c *Client) replyLimitKeys(messageID string) []string {
+	if chatID := c.chats.get(messageID); chatID != "" {
+		return []string{appLimitKey, chatLimitKey(chatID)}
+	}
+	return []string{appLimitKey, messageLimitKey(messageID)}
+}
+
+// updateLimitKeys are the send limits an edit of messageID counts against
+func (c *Client) updateLimitKeys(messageID string) []string {
+	keys := []string{appLimitKey, messageLimitKey(messageID)}
+	if chatID := c.chats.get(messageID); chatID != "" {
+		keys = append(keys, chatLimitKey(chatID))
+	}
+	return keys
+}
+
+// maxIdleBuckets is how many buckets are kept before full ones are dropped
+const maxIdleBuckets = 1000
+
+type bucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// throttle is a set of token buckets that spaces out requests instead of
+// letting Feishu reject them. The app bucket refills at appRate, every
+// other key at chatRate.
+type throttle struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+func newThrottle() *throttle {
+	return &throttle{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// reserve takes a token from each bucket and returns how long to wait
+// before the tokens are available. Buckets go into debt so that callers
+// queue up in order.
+func (t *throttle) reserve(keys ...string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	if len(t.buckets) > maxIdleBuckets {
+		t.prune(now)
+	}
+
+	var wait time.Duration
+	for _, key := range keys {
+		b := t.buckets[key]
+		if b == nil {
+			rate := float64(chatRate)
+			if key == appLimitKey {
+				rate = appRate
+			}
+			b = &bucket{rate: rate, tokens: rate, last: now}
+			t.buckets[key] = b
+		}
+		b.refill(now)
+		b.tokens--
+		if b.tokens < 0 {
+			if d := time.Duration(-b.tokens / b.rate * float64(time.Second)); d > wait {
+				wait = d
+			}
+		}
+	}
+	return wait
+}
+
+// wait blocks until a request may be sent under the limits of keys
+func (t *throttle) wait(ctx context.Context, keys ...string) error {
+	d := t.reserve(keys...)
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// prune drops buckets that have refilled, which are the same as new ones
+func (t *throttle) prune(now time.Time) {
+	for key, b := range t.buckets {
+		b.refill(now)
+		if b.tokens >= b.rate {
+			delete(t.buckets, key)
+		}
+	}
+}
+
+func (b *bucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+}
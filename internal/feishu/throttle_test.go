@@ -0,0 +1,74 @@
+package feishu
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestThrottleSpacesOutRequests(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	th := newThrottle()
+	th.now = func() time.Time { return now }
+
+	chat := chatLimitKey("oc_1")
+	for i := 0; i < chatRate; i++ {
+		if d := th.reserve(appLimitKey, chat); d != 0 {
+			t.Fatalf("request %d waits %s, want the burst to pass", i+1, d)
+		}
+	}
+	if d := th.reserve(appLimitKey, chat); d != time.Second/chatRate {
+		t.Fatalf("request after the burst waits %s, want %s", d, time.Second/chatRate)
+	}
+	if d := th.reserve(appLimitKey, chatLimitKey("oc_2")); d != 0 {
+		t.Fatalf("another chat waits %s, want no wait", d)
+	}
+
+	now = now.Add(time.Second)
+	if d := th.reserve(appLimitKey, chat); d != 0 {
+		t.Fatalf("request a second later waits %s, want no wait", d)
+	}
+}
+
+func TestThrottlePrunesFullBuckets(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	th := newThrottle()
+	th.now = func() time.Time { return now }
+
+	for i := 0; i <= maxIdleBuckets; i++ {
+		th.reserve(messageLimitKey(fmt.Sprintf("om_%d", i)))
+	}
+	now = now.Add(time.Second)
+	th.reserve(appLimitKey)
+	if len(th.buckets) != 1 {
+		t.Fatalf("kept %d buckets, want only the new app bucket", len(th.buckets))
+	}
+}
+
+func TestRepliesCountAgainstTheChat(t *testing.T) {
+	client := NewClient("cli_test", "secret", Options{})
+	client.chats.add("om_in", "oc_1")
+
+	if keys := client.replyLimitKeys("om_in"); !reflect.DeepEqual(keys, []string{appLimitKey, chatLimitKey("oc_1")}) {
+		t.Fatalf("replyLimitKeys(known) = %v", keys)
+	}
+	if keys := client.updateLimitKeys("om_in"); !reflect.DeepEqual(keys, []string{appLimitKey, messageLimitKey("om_in"), chatLimitKey("oc_1")}) {
+		t.Fatalf("updateLimitKeys(known) = %v", keys)
+	}
+	if keys := client.replyLimitKeys("om_other"); !reflect.DeepEqual(keys, []string{appLimitKey, messageLimitKey("om_other")}) {
+		t.Fatalf("replyLimitKeys(unknown) = %v", keys)
+	}
+
+	// Sent messages are remembered too, so edits of a reply count against
+	// its chat
+	server, _ := scriptedOpenAPI(t, `{"code":0,"msg":"ok","data":{"message_id":"om_sent"}}`)
+	client = NewClient("cli_test", "secret", Options{APIBase: server.URL, ReplyFormat: ReplyFormatText})
+	if _, err := client.SendMessage(context.Background(), "oc_2", "你好"); err != nil {
+		t.Fatal(err)
+	}
+	if chatID := client.chats.get("om_sent"); chatID != "oc_2" {
+		t.Fatalf("chat of sent message = %q, want oc_2", chatID)
+	}
+}
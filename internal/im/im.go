@@ -0,0 +1,157 @@
+// Package im defines the platform-neutral message model shared by the bridge
+// and the IM platform adapters.
+package im
+
+import (
+	"context"
+	"errors"
+)
+
+// Chat types
+const (
+	ChatTypeP2P   = "p2p"
+	ChatTypeGroup = "group"
+)
+
+// Message represents a received message
+type Message struct {
+	// Platform is the name of the adapter that received the message
+	Platform  string
+	MessageID string
+	// EventID identifies the delivery, which a platform may repeat for the
+	// same message; empty when the platform has none
+	EventID  string
+	ChatID   string
+	ChatType string
+	// ChatName is the group name when the event carries it
+	ChatName string
+	Content  string
+	Sender   Sender
+	// ThreadID is the root message of the thread the message belongs to,
+	// empty when it does not belong to one
+	ThreadID string
+	Mentions []Mention
+	// Resources are files attached to the message, fetched with a Downloader
+	Resources []Resource
+	// ButtonMessageID is set on messages produced by pressing a Button and
+	// names the sent message that carries the button
+	ButtonMessageID string
+}
+
+// Sender identifies who sent a message
+type Sender struct {
+	// ID is the platform user ID, e.g. the open_id on Feishu
+	ID string
+	// UnionID and UserID are the other Feishu user IDs, when available
+	UnionID string
+	UserID  string
+	// Type is the kind of sender, e.g. "user" or "app"
+	Type string
+	// Name is the display name when the event carries it
+	Name string
+	// TenantKey identifies the sender's organization on Feishu
+	TenantKey string
+}
+
+// Mention represents a user mention
+type Mention struct {
+	// Key is the placeholder for the mention in Content, if any
+	Key       string
+	ID        string
+	UnionID   string
+	UserID    string
+	Name      string
+	TenantKey string
+	// Bot reports whether the mention addresses this bot
+	Bot bool
+}
+
+// Resource types
+const (
+	ResourceImage = "image"
+	ResourceFile  = "file"
+)
+
+// Resource is an image or file attached to a message. Its content is not
+// part of the event and must be downloaded through the platform API.
+type Resource struct {
+	Type string
+	// Key identifies the resource within its message
+	Key  string
+	Name string
+}
+
+// Handler is called when a message is received
+type Handler func(msg *Message) error
+
+// Messenger is an IM platform adapter
+type Messenger interface {
+	// Platform returns the adapter name, used as the session key prefix
+	Platform() string
+	// Start receives messages until ctx is done or the connection fails
+	Start(ctx context.Context, handler Handler) error
+	// SendMessage sends a text message to a chat and returns its message ID
+	SendMessage(ctx context.Context, chatID, text string) (string, error)
+}
+
+// Updater is implemented by messengers that can edit sent messages
+type Updater interface {
+	UpdateMessage(ctx context.Context, messageID, text string) error
+}
+
+// Deleter is implemented by messengers that can delete sent messages
+type Deleter interface {
+	DeleteMessage(ctx context.Context, messageID string) error
+}
+
+// ErrTooLarge is returned by DownloadResource for resources over the limit
+var ErrTooLarge = errors.New("resource too large")
+
+// Downloader is implemented by messengers that can fetch message resources
+type Downloader interface {
+	// DownloadResource returns the content of a resource attached to
+	// messageID. It stops reading and returns an error wrapping ErrTooLarge
+	// once the resource exceeds maxBytes.
+	DownloadResource(ctx context.Context, messageID string, res Resource, maxBytes int64) ([]byte, error)
+}
+
+// Limiter is implemented by messengers that cap the size of a message
+type Limiter interface {
+	// MaxMessageBytes is the largest text SendMessage and UpdateMessage accept
+	MaxMessageBytes() int
+}
+
+// FileSender is implemented by messengers that can send a file to a chat
+type FileSender interface {
+	SendFile(ctx context.Context, chatID, name string, data []byte) (string, error)
+}
+
+// Replier is implemented by messengers that can answer a specific message
+type Replier interface {
+	// ReplyMessage replies to messageID, as a thread reply when inThread is set
+	ReplyMessage(ctx context.Context, messageID, text string, inThread bool) (string, error)
+}
+
+// Directory is implemented by messengers that can look up display names
+type Directory interface {
+	// UserName returns the display name of a user, given Sender.ID
+	UserName(ctx context.Context, userID string) (string, error)
+	// ChatName returns the name of a group chat
+	ChatName(ctx context.Context, chatID string) (string, error)
+}
+
+// Button is a button shown under a sent message. Pressing it delivers a
+// Message whose Content is Command, from the user who pressed it.
+type Button struct {
+	Text    string
+	Command string
+}
+
+// ButtonSetter is implemented by messengers that can show buttons under
+// sent messages
+type ButtonSetter interface {
+	// SetButtons shows buttons under messageID from its next update on;
+	// nil removes them. It reports false when the message cannot show
+	// buttons, such as a plain text message.
+	SetButtons(messageID string, buttons []Button) bool
+}
@@ -0,0 +1,249 @@
+// Package ratelimit implements token-bucket rate limits and daily quotas
+// whose counters survive restarts.
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/fsutil"
+)
+
+// dayFormat keys daily quotas by local calendar day
+const dayFormat = "2006-01-02"
+
+// saveDelay batches the counter changes of busy periods into one write
+const saveDelay = 5 * time.Second
+
+// Limit configures the limits of one key. The zero Limit allows everything.
+type Limit struct {
+	// PerMinute is the rate at which the bucket refills, 0 disables the rate limit
+	PerMinute float64
+	// Burst is the bucket size, 0 uses PerMinute rounded up
+	Burst int
+	// Daily caps requests per calendar day, 0 disables the quota
+	Daily int
+}
+
+func (l Limit) enabled() bool {
+	return l.PerMinute > 0 || l.Daily > 0
+}
+
+func (l Limit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return math.Max(1, math.Ceil(l.PerMinute))
+}
+
+// Request asks to take one request from key under limit
+type Request struct {
+	Key   string
+	Limit Limit
+}
+
+// Result is the outcome of Allow
+type Result struct {
+	Allowed bool
+	// Key is the key that refused the request
+	Key string
+	// RetryAfter is how long until the rate limit lets a request through,
+	// zero when the daily quota refused it
+	RetryAfter time.Duration
+	// Daily is the quota that was used up, zero when the rate limit refused it
+	Daily int
+}
+
+// Usage is the state of one key
+type Usage struct {
+	// Tokens left in the bucket
+	Tokens float64
+	// Used is the number of requests today
+	Used int
+}
+
+// counter is the persisted state of one key
+type counter struct {
+	Tokens  float64   `json:"tokens"`
+	Updated time.Time `json:"updated"`
+	Day     string    `json:"day"`
+	Used    int       `json:"used"`
+}
+
+// Limiter tracks counters per key and saves them to a JSON file a few
+// seconds after they change. An empty path keeps them in memory. Call Close
+// to save the latest counters before exiting.
+type Limiter struct {
+	path string
+	now  func() time.Time
+
+	mu       sync.Mutex
+	counters map[string]*counter
+	// saveTimer is set while a save is scheduled
+	saveTimer *time.Timer
+}
+
+// New creates a limiter, loading counters saved at path
+func New(path string) (*Limiter, error) {
+	l := &Limiter{
+		path:     path,
+		now:      time.Now,
+		counters: make(map[string]*counter),
+	}
+	if path == "" {
+		return l, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &l.counters); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	return l, nil
+}
+
+// Allow takes one request from every key, or from none of them if any
+// key is over its limit. Counters are saved later, and only when a limit
+// applies.
+func (l *Limiter) Allow(reqs ...Request) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	counters := make([]*counter, len(reqs))
+	for i, req := range reqs {
+		c := l.refill(req.Key, req.Limit, now)
+		counters[i] = c
+
+		if req.Limit.Daily > 0 && c.Used >= req.Limit.Daily {
+			return Result{Key: req.Key, Daily: req.Limit.Daily}
+		}
+		if req.Limit.PerMinute > 0 && c.Tokens < 1 {
+			wait := time.Duration((1 - c.Tokens) / req.Limit.PerMinute * float64(time.Minute))
+			return Result{Key: req.Key, RetryAfter: wait}
+		}
+	}
+
+	limited := false
+	for i, req := range reqs {
+		if req.Limit.PerMinute > 0 {
+			counters[i].Tokens--
+		}
+		counters[i].Used++
+		limited = limited || req.Limit.enabled()
+	}
+
+	// Usage without limits is only shown by /quota, which can start from
+	// zero after a restart
+	if limited {
+		l.scheduleSave()
+	}
+	return Result{Allowed: true}
+}
+
+// Usage returns the state of key under limit without taking a request
+func (l *Limiter) Usage(key string, limit Limit) Usage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c := l.refill(key, limit, l.now())
+	return Usage{Tokens: c.Tokens, Used: c.Used}
+}
+
+// Reset clears the counters of key
+func (l *Limiter) Reset(key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.counters, key)
+	return l.save()
+}
+
+// Close saves counters whose save is still pending
+func (l *Limiter) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.saveTimer == nil {
+		return nil
+	}
+	return l.save()
+}
+
+// scheduleSave saves the counters after saveDelay unless a save is already
+// pending. It must be called with l.mu held.
+func (l *Limiter) scheduleSave() {
+	if l.path == "" || l.saveTimer != nil {
+		return
+	}
+	l.saveTimer = time.AfterFunc(saveDelay, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.saveTimer == nil {
+			return
+		}
+		if err := l.save(); err != nil {
+			// Counting goes on in memory; only a restart would lose it
+			log.Printf("[RateLimit] Failed to save counters: %v", err)
+		}
+	})
+}
+
+// refill brings the counter of key up to date. It must be called with l.mu held.
+func (l *Limiter) refill(key string, limit Limit, now time.Time) *counter {
+	day := now.Format(dayFormat)
+	c, ok := l.counters[key]
+	if !ok {
+		c = &counter{Tokens: limit.burst(), Updated: now, Day: day}
+		l.counters[key] = c
+	}
+
+	if elapsed := now.Sub(c.Updated); elapsed > 0 {
+		c.Tokens += elapsed.Minutes() * limit.PerMinute
+	}
+	c.Tokens = math.Min(c.Tokens, limit.burst())
+	c.Updated = now
+	if c.Day != day {
+		c.Day, c.Used = day, 0
+	}
+
+	return c
+}
+
+// save writes the counters that still matter and cancels a pending save.
+// It must be called with l.mu held.
+func (l *Limiter) save() error {
+	if l.saveTimer != nil {
+		l.saveTimer.Stop()
+		l.saveTimer = nil
+	}
+	if l.path == "" {
+		return nil
+	}
+
+	// Counters from earlier days have no requests left to remember
+	today := l.now().Format(dayFormat)
+	for key, c := range l.counters {
+		if c.Day != today {
+			delete(l.counters, key)
+		}
+	}
+
+	data, err := json.MarshalIndent(l.counters, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return fsutil.WriteFileAtomic(l.path, data, 0600)
+}
@@ -0,0 +1,128 @@
+package ratelimit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeClock returns a limiter clock that tests can move forward
+func fakeClock(l *Limiter) *time.Time {
+	now := time.Date(2026, 3, 2, 10, 0, 0, 0, time.Local)
+	l.now = func() time.Time { return now }
+	return &now
+}
+
+func TestTokenBucket(t *testing.T) {
+	l, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := fakeClock(l)
+	limit := Limit{PerMinute: 2, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		if res := l.Allow(Request{Key: "u", Limit: limit}); !res.Allowed {
+			t.Fatalf("request %d refused within the burst", i)
+		}
+	}
+	res := l.Allow(Request{Key: "u", Limit: limit})
+	if res.Allowed || res.Key != "u" || res.RetryAfter != 30*time.Second {
+		t.Fatalf("third request = %+v, want refused for 30s", res)
+	}
+
+	*now = now.Add(30 * time.Second)
+	if res := l.Allow(Request{Key: "u", Limit: limit}); !res.Allowed {
+		t.Fatal("request refused after the bucket refilled")
+	}
+}
+
+func TestDailyQuota(t *testing.T) {
+	l, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := fakeClock(l)
+	sender := Request{Key: "sender", Limit: Limit{Daily: 5}}
+	chat := Request{Key: "chat", Limit: Limit{Daily: 2}}
+
+	for i := 0; i < 2; i++ {
+		if res := l.Allow(sender, chat); !res.Allowed {
+			t.Fatalf("request %d refused within the quota", i)
+		}
+	}
+	res := l.Allow(sender, chat)
+	if res.Allowed || res.Key != "chat" || res.Daily != 2 {
+		t.Fatalf("third request = %+v, want refused by the chat quota", res)
+	}
+	// A refused request takes nothing from the other keys
+	if used := l.Usage("sender", sender.Limit).Used; used != 2 {
+		t.Fatalf("sender used %d, want 2", used)
+	}
+
+	*now = now.Add(24 * time.Hour)
+	if res := l.Allow(sender, chat); !res.Allowed {
+		t.Fatal("request refused on the next day")
+	}
+}
+
+func TestPersistAndReset(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ratelimits.json")
+	limit := Limit{Daily: 1}
+
+	l, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := l.Allow(Request{Key: "u", Limit: limit}); !res.Allowed {
+		t.Fatalf("first request = %+v", res)
+	}
+
+	// Saving waits for more changes, and Close does it right away
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("counters saved on every request, stat error = %v", err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The quota survives a restart
+	l, err = New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := l.Allow(Request{Key: "u", Limit: limit}); res.Allowed {
+		t.Fatal("request allowed after restart, want the quota to persist")
+	}
+
+	if err := l.Reset("u"); err != nil {
+		t.Fatal(err)
+	}
+	if res := l.Allow(Request{Key: "u", Limit: limit}); !res.Allowed {
+		t.Fatal("request refused after reset")
+	}
+}
+
+func TestUnlimitedRequestsAreNotSaved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ratelimits.json")
+	l, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if res := l.Allow(Request{Key: "u"}, Request{Key: "c"}); !res.Allowed {
+			t.Fatal("request refused without limits")
+		}
+	}
+	if used := l.Usage("u", Limit{}).Used; used != 3 {
+		t.Fatalf("used %d, want 3", used)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("counters saved without limits, stat error = %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+// Package tokencache caches the access tokens that IM platform APIs hand
+// out for a limited time.
+package tokencache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// refreshMargin renews a token this long before it expires, so in-flight
+// requests never carry an expired one
+const refreshMargin = 5 * time.Minute
+
+// FetchFunc gets a new token and how long it stays valid
+type FetchFunc func(ctx context.Context) (token string, expiresIn time.Duration, err error)
+
+// Cache holds one access token. Concurrent callers share a single fetch.
+type Cache struct {
+	fetch FetchFunc
+	now   func() time.Time
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// New creates a cache that gets tokens from fetch
+func New(fetch FetchFunc) *Cache {
+	return &Cache{fetch: fetch, now: time.Now}
+}
+
+// Get returns the cached token, fetching a new one when there is none or
+// it is about to expire
+func (c *Cache) Get(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && c.now().Before(c.expiry) {
+		return c.token, nil
+	}
+	return c.refreshLocked(ctx)
+}
+
+// Refresh replaces a token the server rejected. A caller whose rejected
+// token was already replaced gets the new one without another fetch.
+func (c *Cache) Refresh(ctx context.Context, rejected string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && c.token != rejected && c.now().Before(c.expiry) {
+		return c.token, nil
+	}
+	return c.refreshLocked(ctx)
+}
+
+func (c *Cache) refreshLocked(ctx context.Context) (string, error) {
+	token, expiresIn, err := c.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// Tokens that live shorter than twice the margin are renewed halfway
+	margin := refreshMargin
+	if expiresIn < 2*margin {
+		margin = expiresIn / 2
+	}
+	c.token = token
+	c.expiry = c.now().Add(expiresIn - margin)
+	return token, nil
+}
@@ -0,0 +1,55 @@
+package tokencache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	fetches := 0
+	c := New(func(ctx context.Context) (string, time.Duration, error) {
+		fetches++
+		return fmt.Sprintf("token-%d", fetches), 2 * time.Hour, nil
+	})
+	now := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if token, err := c.Get(context.Background()); err != nil || token != "token-1" {
+			t.Fatalf("Get() = %q, %v; want token-1", token, err)
+		}
+	}
+
+	// Renewed ahead of the expiry
+	now = now.Add(2*time.Hour - refreshMargin)
+	if token, _ := c.Get(context.Background()); token != "token-2" {
+		t.Fatalf("Get() near expiry = %q, want token-2", token)
+	}
+
+	// Only the first of several callers holding a rejected token fetches
+	for i := 0; i < 2; i++ {
+		if token, _ := c.Refresh(context.Background(), "token-2"); token != "token-3" {
+			t.Fatalf("Refresh(token-2) = %q, want token-3", token)
+		}
+	}
+	if fetches != 3 {
+		t.Fatalf("fetched %d tokens, want 3", fetches)
+	}
+}
+
+func TestCacheShortLivedToken(t *testing.T) {
+	fetches := 0
+	c := New(func(ctx context.Context) (string, time.Duration, error) {
+		fetches++
+		return "token", time.Minute, nil
+	})
+
+	// A token shorter-lived than the refresh margin is still reused
+	c.Get(context.Background())
+	c.Get(context.Background())
+	if fetches != 1 {
+		t.Fatalf("fetched %d tokens, want 1", fetches)
+	}
+}
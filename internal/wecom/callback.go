@@ -0,0 +1,151 @@
+package wecom
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+// maxCallbackBody bounds the size of a callback request
+const maxCallbackBody = 1 << 20
+
+// encryptedEnvelope is the body WeCom POSTs to the callback URL
+type encryptedEnvelope struct {
+	ToUserName string `xml:"ToUserName"`
+	AgentID    string `xml:"AgentID"`
+	Encrypt    string `xml:"Encrypt"`
+}
+
+// callbackMessage is a decrypted application message
+type callbackMessage struct {
+	ToUserName   string `xml:"ToUserName"`
+	FromUserName string `xml:"FromUserName"`
+	CreateTime   int64  `xml:"CreateTime"`
+	MsgType      string `xml:"MsgType"`
+	Content      string `xml:"Content"`
+	MsgID        string `xml:"MsgId"`
+	AgentID      int    `xml:"AgentID"`
+}
+
+// Start serves the callback URL and passes received messages to handler
+// until ctx is done
+func (c *Client) Start(ctx context.Context, handler im.Handler) error {
+	c.handler = handler
+
+	mux := http.NewServeMux()
+	mux.Handle(c.cfg.Path, c)
+
+	server := &http.Server{
+		Addr:              c.cfg.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("[WeCom] Listening for callbacks on %s%s (corpId=%s, agentId=%d)",
+		c.cfg.Listen, c.cfg.Path, c.cfg.CorpID, c.cfg.AgentID)
+
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// ServeHTTP handles the URL verification (GET) and message callbacks (POST)
+func (c *Client) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	signature := query.Get("msg_signature")
+	timestamp := query.Get("timestamp")
+	nonce := query.Get("nonce")
+
+	switch r.Method {
+	case http.MethodGet:
+		echo := query.Get("echostr")
+		if err := c.crypt.verify(signature, timestamp, nonce, echo); err != nil {
+			log.Printf("[WeCom] Rejected URL verification: %v", err)
+			http.Error(w, "invalid signature", http.StatusForbidden)
+			return
+		}
+		plaintext, err := c.crypt.decrypt(echo)
+		if err != nil {
+			log.Printf("[WeCom] Failed to decrypt echostr: %v", err)
+			http.Error(w, "invalid echostr", http.StatusBadRequest)
+			return
+		}
+		w.Write(plaintext)
+
+	case http.MethodPost:
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxCallbackBody))
+		if err != nil {
+			http.Error(w, "failed to read body", http.StatusBadRequest)
+			return
+		}
+
+		var envelope encryptedEnvelope
+		if err := xml.Unmarshal(body, &envelope); err != nil {
+			http.Error(w, "invalid body", http.StatusBadRequest)
+			return
+		}
+		if err := c.crypt.verify(signature, timestamp, nonce, envelope.Encrypt); err != nil {
+			log.Printf("[WeCom] Rejected callback: %v", err)
+			http.Error(w, "invalid signature", http.StatusForbidden)
+			return
+		}
+		plaintext, err := c.crypt.decrypt(envelope.Encrypt)
+		if err != nil {
+			log.Printf("[WeCom] Failed to decrypt callback: %v", err)
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+
+		var msg callbackMessage
+		if err := xml.Unmarshal(plaintext, &msg); err != nil {
+			log.Printf("[WeCom] Failed to parse callback message: %v", err)
+			http.Error(w, "invalid message", http.StatusBadRequest)
+			return
+		}
+
+		// An empty 200 tells WeCom not to retry; replies go through the API
+		w.WriteHeader(http.StatusOK)
+		c.handleMessage(&msg)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleMessage converts a callback message into an im.Message
+func (c *Client) handleMessage(msg *callbackMessage) {
+	// Only handle text messages; events such as enter_agent are ignored
+	if msg.MsgType != "text" {
+		return
+	}
+
+	// App messages are always 1:1 between a member and the app
+	message := &im.Message{
+		Platform:  Platform,
+		MessageID: msg.MsgID,
+		ChatID:    msg.FromUserName,
+		ChatType:  im.ChatTypeP2P,
+		Content:   strings.TrimSpace(msg.Content),
+		Sender:    im.Sender{ID: msg.FromUserName},
+	}
+
+	if c.handler != nil {
+		if err := c.handler(message); err != nil {
+			log.Printf("[WeCom] Failed to handle message: %v", err)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package wecom
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+	"github.com/wy51ai/moltbotCNAPP/internal/tokencache"
+)
+
+// Platform is the adapter name used in session keys
+const Platform = "wecom"
+
+// DefaultAPIBase is the WeCom server API endpoint
+const DefaultAPIBase = "https://qyapi.weixin.qq.com"
+
+// Error codes that mean the cached access token must be refreshed
+const (
+	errCodeInvalidToken = 40014
+	errCodeExpiredToken = 42001
+)
+
+// Config configures a WeCom application adapter
+type Config struct {
+	CorpID     string
+	CorpSecret string
+	AgentID    int
+	// Token and EncodingAESKey are set on the app's "接收消息" page
+	Token          string
+	EncodingAESKey string
+	// Listen is the address of the callback server, e.g. ":8090"
+	Listen string
+	// Path is the callback URL path, defaults to /wecom/callback
+	Path string
+	// APIBase overrides DefaultAPIBase, e.g. for a local fake
+	APIBase string
+}
+
+// Client is a WeCom application client. It receives messages through the
+// app callback URL and replies through the message API.
+type Client struct {
+	cfg        Config
+	crypt      *msgCrypt
+	httpClient *http.Client
+	handler    im.Handler
+	tokens     *tokencache.Cache
+}
+
+// NewClient creates a new WeCom client
+func NewClient(cfg Config) (*Client, error) {
+	crypt, err := newMsgCrypt(cfg.Token, cfg.EncodingAESKey, cfg.CorpID)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Path == "" {
+		cfg.Path = "/wecom/callback"
+	}
+	if cfg.APIBase == "" {
+		cfg.APIBase = DefaultAPIBase
+	}
+	cfg.APIBase = strings.TrimRight(cfg.APIBase, "/")
+
+	c := &Client{
+		cfg:        cfg,
+		crypt:      crypt,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+	c.tokens = tokencache.New(c.fetchToken)
+	return c, nil
+}
+
+// Platform returns the adapter name
+func (c *Client) Platform() string {
+	return Platform
+}
+
+// maxTextBytes is the size limit of a text message's content
+const maxTextBytes = 2048
+
+// MaxMessageBytes is the longest reply that fits in one message
+func (c *Client) MaxMessageBytes() int {
+	return maxTextBytes
+}
+
+// apiResponse carries the status fields of every WeCom API response
+type apiResponse struct {
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+}
+
+// SendMessage sends a text message to a user. chatID is the member's userid.
+func (c *Client) SendMessage(ctx context.Context, chatID, text string) (string, error) {
+	var resp struct {
+		apiResponse
+		MsgID string `json:"msgid"`
+	}
+	err := c.callAPI(ctx, "/cgi-bin/message/send", map[string]interface{}{
+		"touser":  chatID,
+		"msgtype": "text",
+		"agentid": c.cfg.AgentID,
+		"text": map[string]string{
+			"content": text,
+		},
+	}, &resp, &resp.apiResponse)
+	if err != nil {
+		return "", fmt.Errorf("failed to send message: %w", err)
+	}
+
+	return resp.MsgID, nil
+}
+
+// DeleteMessage recalls a message sent by the app within the last 24 hours
+func (c *Client) DeleteMessage(ctx context.Context, messageID string) error {
+	var resp apiResponse
+	err := c.callAPI(ctx, "/cgi-bin/message/recall", map[string]string{
+		"msgid": messageID,
+	}, &resp, &resp)
+	if err != nil {
+		return fmt.Errorf("failed to delete message: %w", err)
+	}
+	return nil
+}
+
+// fetchToken gets a new access token with the app's corpsecret
+func (c *Client) fetchToken(ctx context.Context) (string, time.Duration, error) {
+	query := url.Values{}
+	query.Set("corpid", c.cfg.CorpID)
+	query.Set("corpsecret", c.cfg.CorpSecret)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.cfg.APIBase+"/cgi-bin/gettoken?"+query.Encode(), nil)
+	if err != nil {
+		return "", 0, err
+	}
+
+	var resp struct {
+		apiResponse
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := c.do(req, &resp); err != nil {
+		return "", 0, fmt.Errorf("failed to get access token: %w", err)
+	}
+	if resp.ErrCode != 0 {
+		return "", 0, fmt.Errorf("failed to get access token: %d %s", resp.ErrCode, resp.ErrMsg)
+	}
+	return resp.AccessToken, time.Duration(resp.ExpiresIn) * time.Second, nil
+}
+
+// callAPI posts body to an authenticated API and decodes the response into
+// out. status must point into out so the error code can be checked. A
+// rejected token is refreshed and the call retried once.
+func (c *Client) callAPI(ctx context.Context, path string, body, out interface{}, status *apiResponse) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	token, err := c.tokens.Get(ctx)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+			c.cfg.APIBase+path+"?access_token="+url.QueryEscape(token), bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		if err := c.do(req, out); err != nil {
+			return err
+		}
+
+		switch status.ErrCode {
+		case 0:
+			return nil
+		case errCodeInvalidToken, errCodeExpiredToken:
+			if attempt == 0 {
+				if token, err = c.tokens.Refresh(ctx, token); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		return fmt.Errorf("%d %s", status.ErrCode, status.ErrMsg)
+	}
+}
+
+// do sends a request and decodes the JSON response
+func (c *Client) do(req *http.Request, out interface{}) error {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return json.Unmarshal(respBody, out)
+}
@@ -0,0 +1,171 @@
+package wecom
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wy51ai/moltbotCNAPP/internal/im"
+)
+
+var testAESKey = strings.TrimSuffix(base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef")), "=")
+
+func newTestClient(t *testing.T, apiBase string) *Client {
+	t.Helper()
+
+	client, err := NewClient(Config{
+		CorpID:         "ww-corp",
+		CorpSecret:     "secret",
+		AgentID:        1000002,
+		Token:          "callback-token",
+		EncodingAESKey: testAESKey,
+		APIBase:        apiBase,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// signedQuery builds the query string WeCom adds to callback requests sent
+// at the given time
+func signedQuery(c *Client, encrypted string, sent time.Time) url.Values {
+	timestamp := strconv.FormatInt(sent.Unix(), 10)
+	query := url.Values{}
+	query.Set("timestamp", timestamp)
+	query.Set("nonce", "nonce-1")
+	query.Set("msg_signature", c.crypt.signature(timestamp, "nonce-1", encrypted))
+	return query
+}
+
+func TestMsgCryptRoundTrip(t *testing.T) {
+	crypt, err := newMsgCrypt("token", testAESKey, "ww-corp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := crypt.encrypt([]byte("<xml>hello</xml>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := crypt.decrypt(encrypted)
+	if err != nil {
+		t.Fatalf("decrypt() error: %v", err)
+	}
+	if string(plaintext) != "<xml>hello</xml>" {
+		t.Fatalf("decrypt() = %q", plaintext)
+	}
+
+	other, _ := newMsgCrypt("token", testAESKey, "ww-other")
+	if _, err := other.decrypt(encrypted); err == nil {
+		t.Fatal("decrypt() accepted a payload for another corp")
+	}
+}
+
+func TestCallbackURLVerification(t *testing.T) {
+	client := newTestClient(t, "")
+
+	echo, _ := client.crypt.encrypt([]byte("echo-123"))
+	query := signedQuery(client, echo, time.Now())
+	query.Set("echostr", echo)
+
+	rec := httptest.NewRecorder()
+	client.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/wecom/callback?"+query.Encode(), nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "echo-123" {
+		t.Fatalf("verification = %d %q, want 200 echo-123", rec.Code, rec.Body.String())
+	}
+
+	query.Set("msg_signature", "forged")
+	rec = httptest.NewRecorder()
+	client.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/wecom/callback?"+query.Encode(), nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("forged verification = %d, want 403", rec.Code)
+	}
+
+	// A correctly signed request captured earlier cannot be replayed
+	stale := signedQuery(client, echo, time.Now().Add(-time.Hour))
+	stale.Set("echostr", echo)
+	rec = httptest.NewRecorder()
+	client.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/wecom/callback?"+stale.Encode(), nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("replayed verification = %d, want 403", rec.Code)
+	}
+}
+
+func TestCallbackMessage(t *testing.T) {
+	client := newTestClient(t, "")
+
+	var received *im.Message
+	client.handler = func(msg *im.Message) error {
+		received = msg
+		return nil
+	}
+
+	plaintext := `<xml><ToUserName><![CDATA[ww-corp]]></ToUserName><FromUserName><![CDATA[zhangsan]]></FromUserName>` +
+		`<CreateTime>1700000000</CreateTime><MsgType><![CDATA[text]]></MsgType><Content><![CDATA[ 帮我看看 ]]></Content>` +
+		`<MsgId>1234567890</MsgId><AgentID>1000002</AgentID></xml>`
+	encrypted, _ := client.crypt.encrypt([]byte(plaintext))
+	body := fmt.Sprintf(`<xml><ToUserName><![CDATA[ww-corp]]></ToUserName><AgentID><![CDATA[1000002]]></AgentID><Encrypt><![CDATA[%s]]></Encrypt></xml>`, encrypted)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/wecom/callback?"+signedQuery(client, encrypted, time.Now()).Encode(), strings.NewReader(body))
+	client.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("callback status = %d, want 200", rec.Code)
+	}
+	if received == nil {
+		t.Fatal("handler was not called")
+	}
+	if received.Platform != Platform || received.ChatID != "zhangsan" || received.ChatType != im.ChatTypeP2P ||
+		received.MessageID != "1234567890" || received.Content != "帮我看看" {
+		t.Fatalf("message = %+v", received)
+	}
+}
+
+func TestSendMessageRefreshesExpiredToken(t *testing.T) {
+	tokens := 0
+	var sent map[string]interface{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cgi-bin/gettoken", func(w http.ResponseWriter, r *http.Request) {
+		tokens++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"errcode":      0,
+			"access_token": fmt.Sprintf("token-%d", tokens),
+			"expires_in":   7200,
+		})
+	})
+	mux.HandleFunc("/cgi-bin/message/send", func(w http.ResponseWriter, r *http.Request) {
+		// The first token is treated as expired
+		if r.URL.Query().Get("access_token") == "token-1" {
+			io.WriteString(w, `{"errcode":42001,"errmsg":"access_token expired"}`)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&sent)
+		io.WriteString(w, `{"errcode":0,"errmsg":"ok","msgid":"msg-1"}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := newTestClient(t, server.URL)
+	msgID, err := client.SendMessage(context.Background(), "zhangsan", "好的")
+	if err != nil {
+		t.Fatalf("SendMessage() error: %v", err)
+	}
+	if msgID != "msg-1" || tokens != 2 {
+		t.Fatalf("SendMessage() = %q after %d token fetches, want msg-1 after 2", msgID, tokens)
+	}
+	if sent["touser"] != "zhangsan" || sent["agentid"] != float64(1000002) {
+		t.Fatalf("send request = %v", sent)
+	}
+}
@@ -0,0 +1,162 @@
+package wecom
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxTimestampSkew is how far a callback's timestamp may be from now, so a
+// captured callback cannot be replayed later
+const maxTimestampSkew = 5 * time.Minute
+
+// msgCrypt implements the WeCom callback signature and AES-256-CBC scheme
+type msgCrypt struct {
+	token     string
+	key       []byte
+	receiveID string
+}
+
+var (
+	errInvalidSignature = errors.New("invalid signature")
+	errStaleTimestamp   = errors.New("timestamp outside the allowed window")
+	errInvalidPadding   = errors.New("invalid padding")
+)
+
+// newMsgCrypt decodes the 43-character EncodingAESKey from the admin console
+func newMsgCrypt(token, encodingAESKey, receiveID string) (*msgCrypt, error) {
+	key, err := base64.StdEncoding.DecodeString(encodingAESKey + "=")
+	if err != nil {
+		return nil, fmt.Errorf("invalid encoding_aes_key: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid encoding_aes_key: decoded to %d bytes, want 32", len(key))
+	}
+
+	return &msgCrypt{
+		token:     token,
+		key:       key,
+		receiveID: receiveID,
+	}, nil
+}
+
+// signature computes msg_signature over the sorted token, timestamp, nonce
+// and encrypted payload
+func (m *msgCrypt) signature(timestamp, nonce, encrypted string) string {
+	parts := []string{m.token, timestamp, nonce, encrypted}
+	sort.Strings(parts)
+
+	sum := sha1.Sum([]byte(strings.Join(parts, "")))
+	return fmt.Sprintf("%x", sum)
+}
+
+// verify checks msg_signature and the timestamp before anything is decrypted
+func (m *msgCrypt) verify(signature, timestamp, nonce, encrypted string) error {
+	if subtle.ConstantTimeCompare([]byte(m.signature(timestamp, nonce, encrypted)), []byte(signature)) != 1 {
+		return errInvalidSignature
+	}
+
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errStaleTimestamp
+	}
+	if skew := time.Since(time.Unix(sec, 0)); skew > maxTimestampSkew || skew < -maxTimestampSkew {
+		return errStaleTimestamp
+	}
+	return nil
+}
+
+// decrypt returns the plaintext of an encrypted payload and checks that it
+// was addressed to our corp
+func (m *msgCrypt) decrypt(encrypted string) ([]byte, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode payload: %w", err)
+	}
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("invalid payload length %d", len(ciphertext))
+	}
+
+	block, err := aes.NewCipher(m.key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, m.key[:aes.BlockSize]).CryptBlocks(plaintext, ciphertext)
+
+	plaintext, err = pkcs7Unpad(plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	// 16 random bytes, 4-byte big-endian length, message, receive ID
+	if len(plaintext) < 20 {
+		return nil, fmt.Errorf("payload too short")
+	}
+	msgLen := int(binary.BigEndian.Uint32(plaintext[16:20]))
+	if msgLen > len(plaintext)-20 {
+		return nil, fmt.Errorf("invalid message length %d", msgLen)
+	}
+	msg := plaintext[20 : 20+msgLen]
+
+	if receiveID := string(plaintext[20+msgLen:]); receiveID != m.receiveID {
+		return nil, fmt.Errorf("payload is for %q, not %q", receiveID, m.receiveID)
+	}
+
+	return msg, nil
+}
+
+// encrypt produces a payload in the same format WeCom sends
+func (m *msgCrypt) encrypt(msg []byte) (string, error) {
+	var buf bytes.Buffer
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	buf.Write(random)
+	binary.Write(&buf, binary.BigEndian, uint32(len(msg)))
+	buf.Write(msg)
+	buf.WriteString(m.receiveID)
+
+	plaintext := pkcs7Pad(buf.Bytes())
+
+	block, err := aes.NewCipher(m.key)
+	if err != nil {
+		return "", err
+	}
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(block, m.key[:aes.BlockSize]).CryptBlocks(ciphertext, plaintext)
+
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// WeCom pads to 32 bytes rather than the AES block size
+const padBlockSize = 32
+
+func pkcs7Pad(data []byte) []byte {
+	n := padBlockSize - len(data)%padBlockSize
+	return append(data, bytes.Repeat([]byte{byte(n)}, n)...)
+}
+
+func pkcs7Unpad(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errInvalidPadding
+	}
+	n := int(data[len(data)-1])
+	if n < 1 || n > padBlockSize || n > len(data) {
+		return nil, errInvalidPadding
+	}
+	return data[:len(data)-n], nil
+}